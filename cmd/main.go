@@ -1,26 +1,81 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
 	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/controllers"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/health"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/metrics"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	klog "k8s.io/klog/v2"
 )
 
+const (
+	configReloadInterval = 10 * time.Second
+
+	// planMaxActions is the number of planned actions kept in dry-run mode
+	planMaxActions = 1000
+)
+
 var (
 	kubeconfig string
 	masterURL  string
+	configFile string
+
+	stateNamespace      string
+	stateName           string
+	reservedIPStateName string
+
+	metricsAddr string
+	healthAddr  string
+
+	dryRun bool
+
+	leaderElect              bool
+	leaderElectNamespace     string
+	leaderElectName          string
+	leaderElectLeaseDuration time.Duration
+	leaderElectRenewDeadline time.Duration
+	leaderElectRetryPeriod   time.Duration
 )
 
 func init() {
 	klog.InitFlags(nil)
 	flag.StringVar(&kubeconfig, "kubeconfig", os.Getenv("KUBECONFIG"), "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", os.Getenv("MASTER_URL"), "URL of the Kubernetes API server. Optional")
+	flag.StringVar(&configFile, "config", os.Getenv("CONFIG_FILE"), "Path to the config file, reloaded on change. It is applied over the environment variables, which are used alone if it is not set or does not exist.")
+	flag.StringVar(&stateNamespace, "state-namespace", os.Getenv("CONFIGMAP_NAMESPACE"), "Namespace of the ConfigMap holding the state of the controllers. The state is not persisted if empty.")
+	flag.StringVar(&stateName, "state-name", "scaleway-k8s-node-coffee-state", "Name of the ConfigMap holding the state of the controllers.")
+	flag.StringVar(&reservedIPStateName, "reserved-ip-state-name", "scaleway-k8s-node-coffee-reserved-ips", "Name of the ConfigMap holding the reserved IP of each node, given back to its replacement.")
+
+	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "Address the /metrics endpoint binds to. Set to 0 to disable it.")
+	flag.StringVar(&healthAddr, "health-probe-bind-address", ":8081", "Address the /healthz and /readyz endpoints bind to. Set to 0 to disable them.")
+
+	flag.BoolVar(&dryRun, "dry-run", false, "Do not send the mutating calls to Scaleway, only log them and serve them on /plan along with the metrics.")
+
+	flag.BoolVar(&leaderElect, "leader-elect", false, "Enable leader election, so that only one replica runs the controllers.")
+	flag.StringVar(&leaderElectNamespace, "leader-elect-namespace", os.Getenv("CONFIGMAP_NAMESPACE"), "Namespace of the leader election Lease.")
+	flag.StringVar(&leaderElectName, "leader-elect-name", "scaleway-k8s-node-coffee", "Name of the leader election Lease.")
+	flag.DurationVar(&leaderElectLeaseDuration, "leader-elect-lease-duration", 15*time.Second, "Duration a standby waits before taking over a non renewed leadership.")
+	flag.DurationVar(&leaderElectRenewDeadline, "leader-elect-renew-deadline", 10*time.Second, "Duration the leader retries to renew its leadership before giving up.")
+	flag.DurationVar(&leaderElectRetryPeriod, "leader-elect-retry-period", 2*time.Second, "Duration between two leader election attempts.")
 }
 
 func main() {
@@ -37,27 +92,225 @@ func main() {
 		klog.Fatalf("could not build kubernetes clientset: %v", err)
 	}
 
-	nodeController, err := controllers.NewNodeController(clientset)
+	scwClient, err := scw.NewClient(scw.WithEnv())
+	if err != nil {
+		klog.Fatalf("could not create scaleway client: %v", err)
+	}
+	scwAPIs := scaleway.WithMetrics(scaleway.NewClient(scwClient))
+
+	var plan *scaleway.Plan
+	if dryRun {
+		klog.Infof("dry-run mode, nothing will be changed on Scaleway")
+		plan = scaleway.NewPlan(planMaxActions)
+		scwAPIs = scaleway.WithDryRun(scwAPIs, plan)
+	}
+
+	cfg, err := config.LoadOrEnv(configFile)
+	if err != nil {
+		klog.Fatalf("could not load config: %v", err)
+	}
+
+	nodeController, err := controllers.NewNodeController(clientset, scwAPIs, cfg)
 	if err != nil {
 		klog.Fatalf("could not create node controller: %v", err)
 	}
-	svcController, err := controllers.NewSvcController(clientset)
+	svcController, err := controllers.NewSvcController(clientset, scwAPIs, cfg)
 	if err != nil {
 		klog.Fatalf("could not create svc controller: %v", err)
 	}
 
-	stop := make(chan struct{})
-	klog.Infof("Starting the coffee machine")
-	nodeController.Wg.Add(1)
-	go nodeController.Run(stop)
-	svcController.Wg.Add(1)
-	go svcController.Run(stop)
+	if stateNamespace != "" {
+		nodeController.UseStateConfigMap(stateNamespace, stateName)
+		nodeController.UseReservedIPAssignmentsConfigMap(stateNamespace, reservedIPStateName)
+	} else if cfg.GarbageCollection.Interval.Duration != 0 {
+		klog.Warningf("no state namespace, the garbage collection will only find the dns records")
+	}
+
+	if metricsAddr != "0" {
+		go serveMetrics(metricsAddr, plan)
+	}
+
+	var leading int32
+	scwChecked := make(chan struct{})
+
+	liveness := health.NewHandler()
+	liveness.AddCheck("node-worker", nodeController.Healthy)
+	liveness.AddCheck("service-worker", svcController.Healthy)
 
+	readiness := health.NewHandler()
+	readiness.AddCheck("scaleway", func() error {
+		select {
+		case <-scwChecked:
+			return nil
+		default:
+			return fmt.Errorf("scaleway credentials not checked yet")
+		}
+	})
+	readiness.AddCheck("informers", func() error {
+		if leaderElect && atomic.LoadInt32(&leading) == 0 {
+			// a standby is ready to take over
+			return nil
+		}
+		if !nodeController.HasSynced() || !svcController.HasSynced() {
+			return fmt.Errorf("informers not synced yet")
+		}
+		return nil
+	})
+
+	if healthAddr != "0" {
+		go serveHealth(healthAddr, liveness, readiness)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT|syscall.SIGTERM)
-	<-c
-	klog.Infof("Stopping the coffee machine")
-	nodeController.Wg.Wait()
-	svcController.Wg.Wait()
-	close(stop)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-c
+		klog.Infof("Stopping the coffee machine")
+		cancel()
+	}()
+
+	go checkScalewayCredentials(ctx, scwAPIs, scwChecked)
+
+	go config.Watch(configFile, configReloadInterval, ctx.Done(), func(cfg *config.Config) {
+		nodeController.UpdateConfig(cfg)
+		svcController.UpdateConfig(cfg)
+	})
+
+	// the lease is only released once the workers stopped, so the election has its own context
+	electionCtx, cancelElection := context.WithCancel(context.Background())
+	var runMu sync.Mutex
+	started := false
+
+	stop := make(chan struct{})
+	run := func(leaderCtx context.Context) {
+		runMu.Lock()
+		if ctx.Err() != nil {
+			runMu.Unlock()
+			cancelElection()
+			return
+		}
+		started = true
+		atomic.StoreInt32(&leading, 1)
+		klog.Infof("Starting the coffee machine")
+		nodeController.Wg.Add(1)
+		go nodeController.Run(stop)
+		svcController.Wg.Add(1)
+		go svcController.Run(stop)
+		runMu.Unlock()
+
+		select {
+		case <-ctx.Done():
+		case <-leaderCtx.Done():
+		}
+
+		close(stop)
+		nodeController.Wg.Wait()
+		svcController.Wg.Wait()
+		cancelElection()
+	}
+
+	if leaderElect {
+		go func() {
+			<-ctx.Done()
+			runMu.Lock()
+			defer runMu.Unlock()
+			if !started {
+				// a standby has nothing to stop
+				cancelElection()
+			}
+		}()
+		runLeaderElection(electionCtx, clientset, run)
+	} else {
+		run(ctx)
+	}
+}
+
+func serveMetrics(addr string, plan *scaleway.Plan) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	if plan != nil {
+		mux.Handle("/plan", plan)
+	}
+
+	klog.Infof("serving metrics on %s", addr)
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		klog.Fatalf("could not serve metrics: %v", err)
+	}
+}
+
+func serveHealth(addr string, liveness http.Handler, readiness http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", liveness)
+	mux.Handle("/readyz", readiness)
+
+	klog.Infof("serving health probes on %s", addr)
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		klog.Fatalf("could not serve health probes: %v", err)
+	}
+}
+
+// checkScalewayCredentials retries until the Scaleway API can be reached, and then closes checked
+func checkScalewayCredentials(ctx context.Context, scwAPIs *scaleway.Client, checked chan struct{}) {
+	err := wait.PollImmediateUntil(10*time.Second, func() (bool, error) {
+		err := scwAPIs.CheckCredentials()
+		if err != nil {
+			klog.Errorf("could not reach the scaleway API: %v", err)
+			return false, nil
+		}
+		return true, nil
+	}, ctx.Done())
+	if err != nil {
+		return
+	}
+	klog.Infof("scaleway credentials checked")
+	close(checked)
+}
+
+// runLeaderElection blocks until ctx is done, running the controllers only while being the leader
+func runLeaderElection(ctx context.Context, clientset kubernetes.Interface, run func(ctx context.Context)) {
+	if leaderElectNamespace == "" {
+		klog.Fatalf("a namespace is needed for leader election, set --leader-elect-namespace or CONFIGMAP_NAMESPACE")
+	}
+
+	id, err := os.Hostname()
+	if err != nil {
+		klog.Fatalf("could not get hostname for leader election: %v", err)
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaderElectName,
+			Namespace: leaderElectNamespace,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: id,
+		},
+	}
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   leaderElectLeaseDuration,
+		RenewDeadline:   leaderElectRenewDeadline,
+		RetryPeriod:     leaderElectRetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: run,
+			OnStoppedLeading: func() {
+				if ctx.Err() == nil {
+					// another replica may already be running the controllers
+					klog.Fatalf("lost leadership %s/%s", leaderElectNamespace, leaderElectName)
+				}
+				klog.Infof("released leadership %s/%s", leaderElectNamespace, leaderElectName)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					klog.Infof("%s is the current leader", identity)
+				}
+			},
+		},
+	})
 }
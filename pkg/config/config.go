@@ -0,0 +1,447 @@
+// Package config holds the versioned configuration of the controllers, read from a file or from the environment.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	APIVersion = "coffee.scaleway.com/v1alpha1"
+	Kind       = "Config"
+
+	DefaultNumberRetries = 30
+
+	DefaultGarbageCollectionGracePeriod = 10 * time.Minute
+
+	DefaultReservedIPRetention = 24 * time.Hour
+
+	DefaultDNSPropagationTimeout = 10 * time.Minute
+
+	NodesIPSourceKubernetes = "kubernetes"
+	NodesIPSourceInstance   = "instance"
+
+	// DefaultReservedIPPool is the name of the pool of ReservedIPs, used by the nodes matching no other pool
+	DefaultReservedIPPool = "default"
+	// KapsulePoolLabel is the label holding the name of the Kapsule pool of a node
+	KapsulePoolLabel = "k8s.scaleway.com/pool-name"
+)
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Config is the configuration of the controllers. Leaving a feature related field empty disables the feature.
+type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// ClusterID identifies the cluster in the resources it owns on Scaleway, like the booked reserved IPs and the DNS records
+	ClusterID string `json:"clusterID,omitempty"`
+
+	// ReverseIPDomain is the domain used for the reverse of the reserved IPs
+	ReverseIPDomain string `json:"reverseIPDomain,omitempty"`
+	// ReverseNameTemplate is the text/template of the reverse names, given a ReverseName, DefaultReverseNameTemplate if empty.
+	// The names must be valid DNS names, and in ReverseIPDomain for the records to be added on Scaleway.
+	ReverseNameTemplate string `json:"reverseNameTemplate,omitempty"`
+	// DNSResolver is the address (host:port) of the DNS server checking that the reverse names resolve
+	// before setting them, the system one if empty
+	DNSResolver string `json:"dnsResolver,omitempty"`
+	// DNSPropagationTimeout is how long a reverse name may take to resolve before the sync fails
+	DNSPropagationTimeout metav1.Duration `json:"dnsPropagationTimeout,omitempty"`
+	// NodeDNS adds DNS records named after the nodes for their IPs
+	NodeDNS NodeDNS `json:"nodeDNS,omitempty"`
+	// DatabaseIDs are the IDs of the databases to allow the nodes on, with an optional region (fr-par/<id>)
+	DatabaseIDs []string `json:"databaseIDs,omitempty"`
+	// RedisIDs are the IDs of the redis clusters to allow the nodes on, with an optional zone (fr-par-1/<id>)
+	RedisIDs []string `json:"redisIDs,omitempty"`
+	// ReservedIPs are the addresses of the flexible IPs to attach to the nodes, with an optional zone (fr-par-1/<address>).
+	// A node only gets an IP of its own zone.
+	ReservedIPs []string `json:"reservedIPs,omitempty"`
+	// ReservedIPTags add the flexible IPs having all these tags to ReservedIPs, looked up on every sync
+	ReservedIPTags []string `json:"reservedIPTags,omitempty"`
+	// ReservedIPAutoProvision books new IPs for ReservedIPs when it has no free IP left
+	ReservedIPAutoProvision *AutoProvision `json:"reservedIPAutoProvision,omitempty"`
+	// ReservedIPPools are named pools of reserved IPs, each for the nodes it selects. A node gets an IP
+	// of the first pool selecting it, and of ReservedIPs if there is none.
+	ReservedIPPools []ReservedIPPool `json:"reservedIPPools,omitempty"`
+	// ReservedIPStickyLabel is the label identifying a node across its replacements, the node name if empty.
+	// A node gets back the reserved IP last attached to a node with the same value when it is free.
+	ReservedIPStickyLabel string `json:"reservedIPStickyLabel,omitempty"`
+	// ReservedIPPendingTaint taints the nodes waiting for a reserved IP with coffee.scaleway.com/reserved-ip-pending:NoSchedule.
+	// The taint is removed once the IP is attached, whether the controller or the node registration added it.
+	ReservedIPPendingTaint bool `json:"reservedIPPendingTaint,omitempty"`
+	// SecurityGroupIDs are the IDs of the security groups to update, with an optional zone (fr-par-1/<id>)
+	SecurityGroupIDs []string `json:"securityGroupIDs,omitempty"`
+	// SecurityGroupNodePortsIPv6 also opens the node ports of the public services to every IPv6 (::/0), for dual-stack clusters
+	SecurityGroupNodePortsIPv6 bool `json:"securityGroupNodePortsIPv6,omitempty"`
+
+	// NumberRetries is the number of retries on error for a given key
+	NumberRetries int `json:"numberRetries"`
+	// NodesIPSource is where the public IP of the nodes is taken from for the ACLs, either instance or kubernetes
+	NodesIPSource string `json:"nodesIPSource,omitempty"`
+
+	// NodeFinalizer holds the deletion of the nodes until every enabled feature cleaned up after them
+	NodeFinalizer bool `json:"nodeFinalizer,omitempty"`
+
+	// GarbageCollection removes the resources left behind by the nodes deleted while the controller was down
+	GarbageCollection GarbageCollection `json:"garbageCollection,omitempty"`
+
+	// NodeSelectors scope the node features to the nodes matching a label selector, by feature name.
+	// The features without selector apply to every node.
+	NodeSelectors map[string]string `json:"nodeSelectors,omitempty"`
+}
+
+// ReservedIPPool is a named pool of reserved IPs for the nodes matching a selector or of a Kapsule pool
+type ReservedIPPool struct {
+	Name string `json:"name"`
+	// NodeSelector is the label selector of the nodes of the pool
+	NodeSelector string `json:"nodeSelector,omitempty"`
+	// KapsulePool selects the nodes of the given Kapsule pool, instead of NodeSelector
+	KapsulePool string `json:"kapsulePool,omitempty"`
+	// IPs are the addresses of the pool, with an optional zone (fr-par-1/<address>)
+	IPs []string `json:"ips,omitempty"`
+	// Tags add the flexible IPs having all these tags to the pool, looked up on every sync
+	Tags []string `json:"tags,omitempty"`
+	// AutoProvision books new IPs for the pool when it has no free IP left
+	AutoProvision *AutoProvision `json:"autoProvision,omitempty"`
+}
+
+// AutoProvision configures the booking of new flexible IPs for a reserved IP pool.
+// The booked IPs are tagged as owned by the cluster, and released once unused for the retention period.
+type AutoProvision struct {
+	// MaxSize is the maximum number of IPs of the pool in a zone, booked or not
+	MaxSize int `json:"maxSize"`
+	// Retention is how long a booked IP stays unused before being released, DefaultReservedIPRetention if 0
+	Retention metav1.Duration `json:"retention,omitempty"`
+}
+
+// Selector returns the label selector of the nodes of the pool
+func (p *ReservedIPPool) Selector() (labels.Selector, error) {
+	if p.KapsulePool != "" {
+		return labels.SelectorFromSet(labels.Set{KapsulePoolLabel: p.KapsulePool}), nil
+	}
+	return labels.Parse(p.NodeSelector)
+}
+
+// NodeDNS configures the A and AAAA records of the nodes, managed in the Scaleway DNS zones holding the domains
+type NodeDNS struct {
+	// Domain gets a <node name>.<domain> record for each public IP of the nodes
+	Domain string `json:"domain,omitempty"`
+	// PrivateDomain gets a <node name>.<private domain> record for the private IP of the nodes, if set
+	PrivateDomain string `json:"privateDomain,omitempty"`
+}
+
+// GarbageCollection configures the periodic removal of the resources owned by deleted nodes
+type GarbageCollection struct {
+	// Interval between two collections, 0 disabling the garbage collection
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// GracePeriod is how long a node must have been gone before its resources are collected
+	GracePeriod metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// Default returns a configuration with every feature disabled
+func Default() *Config {
+	return &Config{
+		APIVersion:    APIVersion,
+		Kind:          Kind,
+		NumberRetries: DefaultNumberRetries,
+		NodesIPSource: NodesIPSourceInstance,
+		GarbageCollection: GarbageCollection{
+			GracePeriod: metav1.Duration{Duration: DefaultGarbageCollectionGracePeriod},
+		},
+		DNSPropagationTimeout: metav1.Duration{Duration: DefaultDNSPropagationTimeout},
+	}
+}
+
+// Parse decodes and validates a YAML or JSON configuration, rejecting unknown fields
+func Parse(data []byte) (*Config, error) {
+	return parseOver(Default(), data)
+}
+
+// parseOverEnv decodes and validates a YAML or JSON configuration over the environment variables:
+// the settings of the file take precedence, and the ones it does not set keep their environment value
+func parseOverEnv(data []byte) (*Config, error) {
+	cfg, err := fromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return parseOver(cfg, data)
+}
+
+// parseOver decodes the configuration over cfg, which it must own, and validates the result
+func parseOver(cfg *Config, data []byte) (*Config, error) {
+	// the file must still set its version
+	cfg.APIVersion = ""
+	cfg.Kind = ""
+
+	err := yaml.UnmarshalStrict(data, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.NodesIPSource == "" {
+		cfg.NodesIPSource = NodesIPSourceInstance
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Load reads the configuration file at path, over the environment variables
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := parseOverEnv(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Validate returns an error listing every invalid field
+func (c *Config) Validate() error {
+	var errs []string
+
+	if c.APIVersion != APIVersion {
+		errs = append(errs, fmt.Sprintf("apiVersion: must be %s, got %q", APIVersion, c.APIVersion))
+	}
+	if c.Kind != Kind {
+		errs = append(errs, fmt.Sprintf("kind: must be %s, got %q", Kind, c.Kind))
+	}
+
+	if c.ReverseIPDomain != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(c.ReverseIPDomain) {
+			errs = append(errs, fmt.Sprintf("reverseIPDomain: %s", msg))
+		}
+	}
+
+	if c.ReverseNameTemplate != "" {
+		if c.ReverseIPDomain == "" {
+			errs = append(errs, "reverseNameTemplate: reverseIPDomain must be set")
+		} else if err := validateReverseNameTemplate(c.ReverseNameTemplate, c.ReverseIPDomain); err != nil {
+			errs = append(errs, fmt.Sprintf("reverseNameTemplate: %v", err))
+		}
+	}
+
+	if c.NodeDNS.Domain != "" {
+		if msgs := validateDNSName(c.NodeDNS.Domain); len(msgs) != 0 {
+			errs = append(errs, fmt.Sprintf("nodeDNS.domain: %s", strings.Join(msgs, ", ")))
+		}
+	}
+	if c.NodeDNS.PrivateDomain != "" {
+		if c.NodeDNS.Domain == "" {
+			errs = append(errs, "nodeDNS.privateDomain: requires nodeDNS.domain")
+		}
+		if c.NodeDNS.PrivateDomain == c.NodeDNS.Domain {
+			errs = append(errs, "nodeDNS.privateDomain: must differ from nodeDNS.domain")
+		}
+		if msgs := validateDNSName(c.NodeDNS.PrivateDomain); len(msgs) != 0 {
+			errs = append(errs, fmt.Sprintf("nodeDNS.privateDomain: %s", strings.Join(msgs, ", ")))
+		}
+	}
+	if c.DNSResolver != "" {
+		if _, _, err := net.SplitHostPort(c.DNSResolver); err != nil {
+			errs = append(errs, fmt.Sprintf("dnsResolver: %v", err))
+		}
+	}
+	if c.DNSPropagationTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Sprintf("dnsPropagationTimeout: must be positive, got %s", c.DNSPropagationTimeout.Duration))
+	}
+
+	for _, id := range c.DatabaseIDs {
+		if err := validateRegionalID(id); err != nil {
+			errs = append(errs, fmt.Sprintf("databaseIDs: %v", err))
+		}
+	}
+	for _, id := range c.RedisIDs {
+		if err := validateZonalID(id); err != nil {
+			errs = append(errs, fmt.Sprintf("redisIDs: %v", err))
+		}
+	}
+	for _, id := range c.SecurityGroupIDs {
+		if err := validateZonalID(id); err != nil {
+			errs = append(errs, fmt.Sprintf("securityGroupIDs: %v", err))
+		}
+	}
+
+	pools := make(map[string]bool)
+	addresses := make(map[string]bool)
+	for _, ip := range c.ReservedIPs {
+		if err := validateZonalIP(ip); err != nil {
+			errs = append(errs, fmt.Sprintf("reservedIPs: %v", err))
+		}
+		addresses[ip[strings.LastIndex(ip, "/")+1:]] = true
+	}
+	if err := validateTags(c.ReservedIPTags); err != nil {
+		errs = append(errs, fmt.Sprintf("reservedIPTags: %v", err))
+	}
+	autoProvision := c.ReservedIPAutoProvision != nil
+	if c.ReservedIPAutoProvision != nil {
+		errs = append(errs, validateAutoProvision("reservedIPAutoProvision", c.ReservedIPAutoProvision)...)
+	}
+	for i, pool := range c.ReservedIPPools {
+		field := fmt.Sprintf("reservedIPPools[%d]", i)
+		switch {
+		case pool.Name == "" || pool.Name == DefaultReservedIPPool:
+			errs = append(errs, fmt.Sprintf("%s.name: must be set and not %s", field, DefaultReservedIPPool))
+		case pools[pool.Name]:
+			errs = append(errs, fmt.Sprintf("%s.name: duplicate pool %s", field, pool.Name))
+		}
+		pools[pool.Name] = true
+
+		if (pool.NodeSelector == "") == (pool.KapsulePool == "") {
+			errs = append(errs, fmt.Sprintf("%s: exactly one of nodeSelector and kapsulePool must be set", field))
+		} else if _, err := pool.Selector(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", field, err))
+		}
+
+		if len(pool.IPs) == 0 && len(pool.Tags) == 0 && pool.AutoProvision == nil {
+			errs = append(errs, fmt.Sprintf("%s: at least one of ips, tags and autoProvision must be set", field))
+		}
+		if pool.AutoProvision != nil {
+			autoProvision = true
+			errs = append(errs, validateAutoProvision(field+".autoProvision", pool.AutoProvision)...)
+		}
+		if err := validateTags(pool.Tags); err != nil {
+			errs = append(errs, fmt.Sprintf("%s.tags: %v", field, err))
+		}
+		for _, ip := range pool.IPs {
+			if err := validateZonalIP(ip); err != nil {
+				errs = append(errs, fmt.Sprintf("%s.ips: %v", field, err))
+			}
+			address := ip[strings.LastIndex(ip, "/")+1:]
+			if addresses[address] {
+				errs = append(errs, fmt.Sprintf("%s.ips: %s is already in another pool", field, address))
+			}
+			addresses[address] = true
+		}
+	}
+
+	if c.ReservedIPStickyLabel != "" {
+		for _, msg := range validation.IsQualifiedName(c.ReservedIPStickyLabel) {
+			errs = append(errs, fmt.Sprintf("reservedIPStickyLabel: %s", msg))
+		}
+	}
+
+	if c.NumberRetries < 0 {
+		errs = append(errs, fmt.Sprintf("numberRetries: must be positive, got %d", c.NumberRetries))
+	}
+
+	if c.NodesIPSource != NodesIPSourceInstance && c.NodesIPSource != NodesIPSourceKubernetes {
+		errs = append(errs, fmt.Sprintf("nodesIPSource: must be %s or %s, got %q", NodesIPSourceInstance, NodesIPSourceKubernetes, c.NodesIPSource))
+	}
+
+	if c.GarbageCollection.Interval.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("garbageCollection.interval: must be positive, got %s", c.GarbageCollection.Interval.Duration))
+	}
+	if c.GarbageCollection.GracePeriod.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("garbageCollection.gracePeriod: must be positive, got %s", c.GarbageCollection.GracePeriod.Duration))
+	}
+
+	if c.ClusterID != "" {
+		for _, msg := range validation.IsDNS1123Label(c.ClusterID) {
+			errs = append(errs, fmt.Sprintf("clusterID: %s", msg))
+		}
+	} else if autoProvision {
+		errs = append(errs, "clusterID: must be set to book reserved IPs")
+	}
+
+	for feature, selector := range c.NodeSelectors {
+		if _, err := labels.Parse(selector); err != nil {
+			errs = append(errs, fmt.Sprintf("nodeSelectors.%s: %v", feature, err))
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func validateRegionalID(s string) error {
+	split := strings.Split(s, "/")
+	switch len(split) {
+	case 1:
+	case 2:
+		if _, err := scw.ParseRegion(split[0]); err != nil {
+			return fmt.Errorf("%q has an invalid region: %v", s, err)
+		}
+	default:
+		return fmt.Errorf("couldn't parse ID %q", s)
+	}
+	if !uuidRegexp.MatchString(split[len(split)-1]) {
+		return fmt.Errorf("%q is not a valid ID", s)
+	}
+	return nil
+}
+
+func validateZonalID(s string) error {
+	split := strings.Split(s, "/")
+	switch len(split) {
+	case 1:
+	case 2:
+		if _, err := scw.ParseZone(split[0]); err != nil {
+			return fmt.Errorf("%q has an invalid zone: %v", s, err)
+		}
+	default:
+		return fmt.Errorf("couldn't parse ID %q", s)
+	}
+	if !uuidRegexp.MatchString(split[len(split)-1]) {
+		return fmt.Errorf("%q is not a valid ID", s)
+	}
+	return nil
+}
+
+func validateZonalIP(s string) error {
+	split := strings.Split(s, "/")
+	switch len(split) {
+	case 1:
+	case 2:
+		if _, err := scw.ParseZone(split[0]); err != nil {
+			return fmt.Errorf("%q has an invalid zone: %v", s, err)
+		}
+	default:
+		return fmt.Errorf("couldn't parse IP %q", s)
+	}
+	if net.ParseIP(split[len(split)-1]) == nil {
+		return fmt.Errorf("%q is not a valid IP", s)
+	}
+	return nil
+}
+
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			return fmt.Errorf("tags must not be empty")
+		}
+	}
+	return nil
+}
+
+func validateAutoProvision(field string, p *AutoProvision) []string {
+	var errs []string
+	if p.MaxSize <= 0 {
+		errs = append(errs, fmt.Sprintf("%s.maxSize: must be greater than 0, got %d", field, p.MaxSize))
+	}
+	if p.Retention.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("%s.retention: must be positive, got %s", field, p.Retention.Duration))
+	}
+	return errs
+}
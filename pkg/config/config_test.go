@@ -0,0 +1,204 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDatabaseID = "11111111-1111-4111-8111-111111111111"
+
+// writeConfig writes the configuration file in the directory and returns its path
+func writeConfig(t *testing.T, dir string, data string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "config.yaml")
+	err := ioutil.WriteFile(path, []byte(data), 0o600)
+	if err != nil {
+		t.Fatalf("could not write config file: %v", err)
+	}
+	return path
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data string
+		// wantedErr is a part of the error, empty if the configuration is valid
+		wantedErr string
+	}{
+		{
+			name: "valid",
+			data: `
+apiVersion: coffee.scaleway.com/v1alpha1
+kind: Config
+reverseIPDomain: example.com
+databaseIDs: ["fr-par/` + testDatabaseID + `"]
+reservedIPPools:
+- name: egress
+  kapsulePool: egress
+  ips: ["51.15.15.15"]
+`,
+		},
+		{
+			name:      "unknown field",
+			data:      "apiVersion: coffee.scaleway.com/v1alpha1\nkind: Config\nreverseDomain: example.com\n",
+			wantedErr: "unknown field",
+		},
+		{
+			name:      "missing version",
+			data:      "reverseIPDomain: example.com\n",
+			wantedErr: "apiVersion: must be",
+		},
+		{
+			name:      "wrong kind",
+			data:      "apiVersion: coffee.scaleway.com/v1alpha1\nkind: Other\n",
+			wantedErr: "kind: must be",
+		},
+		{
+			name:      "invalid domain",
+			data:      "apiVersion: coffee.scaleway.com/v1alpha1\nkind: Config\nreverseIPDomain: Example_com\n",
+			wantedErr: "reverseIPDomain:",
+		},
+		{
+			name:      "invalid database ID",
+			data:      "apiVersion: coffee.scaleway.com/v1alpha1\nkind: Config\ndatabaseIDs: [database]\n",
+			wantedErr: "databaseIDs:",
+		},
+		{
+			name: "IP in two pools",
+			data: `
+apiVersion: coffee.scaleway.com/v1alpha1
+kind: Config
+reservedIPs: ["51.15.15.15"]
+reservedIPPools:
+- name: egress
+  kapsulePool: egress
+  ips: ["51.15.15.15"]
+`,
+			wantedErr: "51.15.15.15 is already in another pool",
+		},
+		{
+			name:      "auto-provision without cluster ID",
+			data:      "apiVersion: coffee.scaleway.com/v1alpha1\nkind: Config\nreservedIPAutoProvision:\n  maxSize: 2\n",
+			wantedErr: "clusterID: must be set",
+		},
+		{
+			name:      "every invalid field",
+			data:      "apiVersion: coffee.scaleway.com/v1alpha1\nkind: Config\nnumberRetries: -1\nnodesIPSource: node\n",
+			wantedErr: "numberRetries: must be positive, got -1, nodesIPSource:",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Parse([]byte(tt.data))
+			if tt.wantedErr == "" {
+				if err != nil {
+					t.Fatalf("could not parse config: %v", err)
+				}
+				if cfg.NumberRetries != DefaultNumberRetries || cfg.NodesIPSource != NodesIPSourceInstance {
+					t.Errorf("expected the defaults to be kept, got %d retries and %s IP source", cfg.NumberRetries, cfg.NodesIPSource)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantedErr) {
+				t.Errorf("expected an error with %q, got %v", tt.wantedErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadOrEnv(t *testing.T) {
+	t.Setenv(ReverseIPDomainEnv, "env.example.com")
+	t.Setenv(DatabaseIDsEnv, testDatabaseID)
+	dir := t.TempDir()
+
+	for _, tt := range []struct {
+		name string
+		path string
+		// wantedDomain is the reverse IP domain, empty if the configuration is invalid
+		wantedDomain string
+	}{
+		{name: "no file", wantedDomain: "env.example.com"},
+		{name: "missing file", path: filepath.Join(dir, "missing.yaml"), wantedDomain: "env.example.com"},
+		{
+			name:         "file over the environment",
+			path:         writeConfig(t, t.TempDir(), "apiVersion: coffee.scaleway.com/v1alpha1\nkind: Config\nreverseIPDomain: file.example.com\n"),
+			wantedDomain: "file.example.com",
+		},
+		{
+			name:         "file without the setting",
+			path:         writeConfig(t, t.TempDir(), "apiVersion: coffee.scaleway.com/v1alpha1\nkind: Config\n"),
+			wantedDomain: "env.example.com",
+		},
+		{
+			name: "invalid file",
+			path: writeConfig(t, t.TempDir(), "kind: Config\n"),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadOrEnv(tt.path)
+			if tt.wantedDomain == "" {
+				if err == nil {
+					t.Errorf("expected the config to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not load config: %v", err)
+			}
+			if cfg.ReverseIPDomain != tt.wantedDomain {
+				t.Errorf("expected reverse IP domain %s, got %s", tt.wantedDomain, cfg.ReverseIPDomain)
+			}
+			if len(cfg.DatabaseIDs) != 1 || cfg.DatabaseIDs[0] != testDatabaseID {
+				t.Errorf("expected the database of the environment, got %v", cfg.DatabaseIDs)
+			}
+		})
+	}
+
+	t.Run("invalid environment", func(t *testing.T) {
+		t.Setenv(NumberRetries, "many")
+		if _, err := LoadOrEnv(""); err == nil {
+			t.Errorf("expected the environment to be rejected")
+		}
+	})
+}
+
+func TestWatch(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), "apiVersion: coffee.scaleway.com/v1alpha1\nkind: Config\nreverseIPDomain: old.example.com\n")
+
+	changes := make(chan *Config, 10)
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		Watch(path, 10*time.Millisecond, stopCh, func(cfg *Config) {
+			changes <- cfg
+		})
+		close(done)
+	}()
+	defer func() {
+		close(stopCh)
+		<-done
+	}()
+	// for Watch to read the initial file first
+	time.Sleep(50 * time.Millisecond)
+
+	// the invalid file is ignored, so the old configuration is kept
+	writeConfig(t, filepath.Dir(path), "apiVersion: coffee.scaleway.com/v1alpha1\nkind: Config\nreverseIPDomain: new.example.com\nunknown: true\n")
+	select {
+	case cfg := <-changes:
+		t.Fatalf("expected the invalid config to be ignored, got %s", cfg.ReverseIPDomain)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	writeConfig(t, filepath.Dir(path), "apiVersion: coffee.scaleway.com/v1alpha1\nkind: Config\nreverseIPDomain: new.example.com\n")
+	select {
+	case cfg := <-changes:
+		if cfg.ReverseIPDomain != "new.example.com" {
+			t.Errorf("expected reverse IP domain new.example.com, got %s", cfg.ReverseIPDomain)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for the config to be reloaded")
+	}
+}
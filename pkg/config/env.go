@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	ReverseIPDomainEnv   = "REVERSE_IP_DOMAIN"
+	DatabaseIDsEnv       = "DATABASE_IDS"
+	RedisIDsEnv          = "REDIS_IDS"
+	ReservedIPsPoolEnv   = "RESERVED_IPS_POOL"
+	ReservedIPsTagsEnv   = "RESERVED_IPS_TAGS"
+	ReservedIPsStickyEnv = "RESERVED_IPS_STICKY_LABEL"
+	SecurityGroupIDs     = "SECURITY_GROUP_IDS"
+	NodeDNSDomain        = "NODE_DNS_DOMAIN"
+	NodeDNSPrivateDomain = "NODE_DNS_PRIVATE_DOMAIN"
+	NumberRetries        = "NUMBER_RETRIES"
+	NodesIPSource        = "NODES_IP_SOURCE"
+)
+
+// FromEnv builds the configuration from the environment variables
+func FromEnv() (*Config, error) {
+	cfg, err := fromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid environment: %v", err)
+	}
+
+	return cfg, nil
+}
+
+// fromEnv returns the default configuration overridden by the environment variables, without validating it
+func fromEnv() (*Config, error) {
+	cfg := Default()
+
+	if os.Getenv(ReverseIPDomainEnv) != "" {
+		cfg.ReverseIPDomain = os.Getenv(ReverseIPDomainEnv)
+	}
+
+	if os.Getenv(DatabaseIDsEnv) != "" {
+		cfg.DatabaseIDs = strings.Split(os.Getenv(DatabaseIDsEnv), ",")
+	}
+
+	if os.Getenv(RedisIDsEnv) != "" {
+		cfg.RedisIDs = strings.Split(os.Getenv(RedisIDsEnv), ",")
+	}
+
+	if os.Getenv(ReservedIPsPoolEnv) != "" {
+		cfg.ReservedIPs = strings.Split(os.Getenv(ReservedIPsPoolEnv), ",")
+	}
+
+	if os.Getenv(ReservedIPsTagsEnv) != "" {
+		cfg.ReservedIPTags = strings.Split(os.Getenv(ReservedIPsTagsEnv), ",")
+	}
+
+	if os.Getenv(ReservedIPsStickyEnv) != "" {
+		cfg.ReservedIPStickyLabel = os.Getenv(ReservedIPsStickyEnv)
+	}
+
+	if os.Getenv(SecurityGroupIDs) != "" {
+		cfg.SecurityGroupIDs = strings.Split(os.Getenv(SecurityGroupIDs), ",")
+	}
+
+	if os.Getenv(NodeDNSDomain) != "" {
+		cfg.NodeDNS.Domain = os.Getenv(NodeDNSDomain)
+	}
+
+	if os.Getenv(NodeDNSPrivateDomain) != "" {
+		cfg.NodeDNS.PrivateDomain = os.Getenv(NodeDNSPrivateDomain)
+	}
+
+	if os.Getenv(NumberRetries) != "" {
+		numberRetriesValue, err := strconv.Atoi(os.Getenv(NumberRetries))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the desired number of retries %s: %v", os.Getenv(NumberRetries), err)
+		}
+		cfg.NumberRetries = numberRetriesValue
+	}
+
+	if os.Getenv(NodesIPSource) == NodesIPSourceKubernetes {
+		cfg.NodesIPSource = NodesIPSourceKubernetes
+	}
+
+	return cfg, nil
+}
+
+// LoadOrEnv reads the configuration file at path over the environment variables, and falls back to
+// the environment variables alone if path is empty or if the file does not exist
+func LoadOrEnv(path string) (*Config, error) {
+	if path == "" {
+		return FromEnv()
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return FromEnv()
+	}
+	return Load(path)
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultReverseNameTemplate names the reverse of 51.16.17.18 18-17-16-51.<domain>
+const DefaultReverseNameTemplate = "{{.ReversedIP}}.{{.Domain}}"
+
+// ReverseName is what the reverse name template has access to
+type ReverseName struct {
+	// IP is the address of the reserved IP
+	IP string
+	// Octets are the bytes of an IPv4 address, in the address order, and empty for an IPv6
+	Octets []int
+	// ReversedIP is the default label of the IP: its octets or nibbles reversed and joined with dashes
+	ReversedIP string
+	// NodeName is the name of the node the IP is attached to
+	NodeName string
+	// Pool is the name of the reserved IP pool of the IP, or of the node if the IP is not listed in a pool
+	Pool string
+	// Index is the position of the IP in the ips of its pool starting at 1, 0 if it is not listed, as the IPs
+	// found by tags or auto-provisioned
+	Index int
+	// Zone is the zone of the IP
+	Zone string
+	// Labels are the labels of the node
+	Labels map[string]string
+	// Domain is the reverse IP domain
+	Domain string
+}
+
+// ParseReverseNameTemplate parses a reverse name template, DefaultReverseNameTemplate if empty
+func ParseReverseNameTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultReverseNameTemplate
+	}
+	return template.New("reverse-name").Parse(text)
+}
+
+// RenderReverseName executes the reverse name template, and checks the result is a valid DNS name
+func RenderReverseName(tmpl *template.Template, data ReverseName) (string, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSuffix(strings.TrimSpace(buf.String()), ".")
+	if msgs := validateDNSName(name); len(msgs) != 0 {
+		return "", fmt.Errorf("invalid reverse name %q: %s", name, strings.Join(msgs, ", "))
+	}
+	return name, nil
+}
+
+// validateDNSName returns why name is not a valid DNS name, each of its labels being checked
+func validateDNSName(name string) []string {
+	var msgs []string
+	if len(name) > validation.DNS1123SubdomainMaxLength {
+		msgs = append(msgs, validation.MaxLenError(validation.DNS1123SubdomainMaxLength))
+	}
+	for _, label := range strings.Split(name, ".") {
+		for _, msg := range validation.IsDNS1123Label(label) {
+			msgs = append(msgs, fmt.Sprintf("label %q: %s", label, msg))
+		}
+	}
+	return msgs
+}
+
+// validateReverseNameTemplate parses the template and renders it on an example, as field errors and invalid
+// names only show then. The labels read by the template are set in the example, for them not to render empty.
+func validateReverseNameTemplate(text string, domain string) error {
+	tmpl, err := ParseReverseNameTemplate(text)
+	if err != nil {
+		return err
+	}
+
+	labels := make(map[string]string)
+	for key := range templateLabelKeys(tmpl.Tree.Root) {
+		labels[key] = "label"
+	}
+
+	_, err = RenderReverseName(tmpl, ReverseName{
+		IP:         "51.16.17.18",
+		Octets:     []int{51, 16, 17, 18},
+		ReversedIP: "18-17-16-51",
+		NodeName:   "node",
+		Pool:       DefaultReservedIPPool,
+		Index:      1,
+		Zone:       "fr-par-1",
+		Labels:     labels,
+		Domain:     domain,
+	})
+	return err
+}
+
+// templateLabelKeys returns the label keys read by the template node, as .Labels.<key> or index .Labels "<key>"
+func templateLabelKeys(node parse.Node) map[string]bool {
+	keys := make(map[string]bool)
+
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			if len(n.Args) >= 3 && n.Args[0].String() == "index" && n.Args[1].String() == ".Labels" {
+				if key, ok := n.Args[2].(*parse.StringNode); ok {
+					keys[key.Text] = true
+				}
+			}
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.FieldNode:
+			if len(n.Ident) >= 2 && n.Ident[0] == "Labels" {
+				keys[n.Ident[1]] = true
+			}
+		}
+	}
+	walk(node)
+
+	return keys
+}
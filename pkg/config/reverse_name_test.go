@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestValidateReverseNameTemplate(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		template string
+		valid    bool
+	}{
+		{name: "default", template: DefaultReverseNameTemplate, valid: true},
+		{name: "pool", template: "{{.Pool}}-{{.ReversedIP}}.{{.Domain}}", valid: true},
+		{name: "label", template: "{{.Labels.role}}-{{.ReversedIP}}.{{.Domain}}", valid: true},
+		{name: "indexed label", template: `{{index .Labels "topology.kubernetes.io/zone"}}.{{.Domain}}`, valid: true},
+		{name: "parse error", template: "{{.Pool}.{{.Domain}}"},
+		{name: "unknown field", template: "{{.Server}}.{{.Domain}}"},
+		{name: "invalid character", template: "{{.Pool}}_{{.Index}}.{{.Domain}}"},
+		{name: "empty label", template: "{{.NodeName}}..{{.Domain}}"},
+		{name: "IP with dots", template: "ip-{{.IP}}.{{.Domain}}", valid: true},
+		{name: "uppercase", template: "Node-{{.ReversedIP}}.{{.Domain}}"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateReverseNameTemplate(tt.template, "example.com")
+			if tt.valid && err != nil {
+				t.Errorf("expected template %q to be valid, got %v", tt.template, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("expected template %q to be rejected", tt.template)
+			}
+		})
+	}
+}
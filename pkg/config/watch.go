@@ -0,0 +1,48 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	klog "k8s.io/klog/v2"
+)
+
+// Watch polls the configuration file at path until stopCh is closed, and calls onChange with the new
+// configuration whenever the file content changes. Invalid configurations are logged and ignored.
+// Polling is used since ConfigMap volumes are updated by swapping a symlink, which file events miss.
+func Watch(path string, interval time.Duration, stopCh <-chan struct{}, onChange func(*Config)) {
+	if path == "" {
+		return
+	}
+
+	last, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		klog.Errorf("could not read config file %s: %v", path, err)
+	}
+
+	wait.Until(func() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				klog.Errorf("could not read config file %s: %v", path, err)
+			}
+			return
+		}
+		if bytes.Equal(data, last) {
+			return
+		}
+		last = data
+
+		cfg, err := parseOverEnv(data)
+		if err != nil {
+			klog.Errorf("ignoring invalid config file %s: %v", path, err)
+			return
+		}
+
+		klog.Infof("config file %s changed, reloading", path)
+		onChange(cfg)
+	}, interval, stopCh)
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	annotationPrefix = "coffee.scaleway.com/"
+
+	// AnnotationReservedIPID is the ID of the reserved IP attached to the node, with its zone (zone/id)
+	AnnotationReservedIPID = annotationPrefix + "reserved-ip-id"
+	// AnnotationReverseName is the reverse set on the public IP of the node
+	AnnotationReverseName = annotationPrefix + "reverse-name"
+	// AnnotationDatabases is the comma separated list of databases (region/id) the node is allowed on
+	AnnotationDatabases = annotationPrefix + "databases"
+	// AnnotationRedisClusters is the comma separated list of redis clusters (zone/id) the node is allowed on
+	AnnotationRedisClusters = annotationPrefix + "redis-clusters"
+	// AnnotationSecurityGroups is the comma separated list of security groups (zone/id) the node IPs are in
+	AnnotationSecurityGroups = annotationPrefix + "security-groups"
+	// AnnotationExcludedFeatures is the comma separated list of features cleaned up as the node does not match their selector
+	AnnotationExcludedFeatures = annotationPrefix + "excluded-features"
+	// AnnotationLastSyncTime is the time of the last sync of the node
+	AnnotationLastSyncTime = annotationPrefix + "last-sync-time"
+	// AnnotationLastSyncError is the error of the last sync of the node, removed once a sync succeeds
+	AnnotationLastSyncError = annotationPrefix + "last-sync-error"
+)
+
+// metadataPatch is a strategic merge patch of labels and annotations, a nil value removing the key
+type metadataPatch struct {
+	Metadata struct {
+		Labels      map[string]*string `json:"labels,omitempty"`
+		Annotations map[string]*string `json:"annotations,omitempty"`
+	} `json:"metadata"`
+}
+
+// patchNodeMetadata patches the labels and annotations of the node, skipping the call if they already have the wanted values
+func (c *NodeController) patchNodeMetadata(node *v1.Node, labels map[string]*string, annotations map[string]*string) error {
+	patch := metadataPatch{}
+	patch.Metadata.Labels = changedValues(node.Labels, labels)
+	patch.Metadata.Annotations = changedValues(node.Annotations, annotations)
+	if len(patch.Metadata.Labels) == 0 && len(patch.Metadata.Annotations) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.clientset.CoreV1().Nodes().Patch(context.Background(), node.Name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		klog.Errorf("could not patch metadata of node %s: %v", node.Name, err)
+		return err
+	}
+	return nil
+}
+
+// setNodeAnnotations patches the annotations of an existing node, an empty value removing the annotation
+func (c *NodeController) setNodeAnnotations(node *v1.Node, annotations map[string]string) error {
+	values := make(map[string]*string, len(annotations))
+	for key, value := range annotations {
+		if value == "" {
+			values[key] = nil
+			continue
+		}
+		v := value
+		values[key] = &v
+	}
+	return c.patchNodeMetadata(node, nil, values)
+}
+
+// setLastSync records the outcome of a sync on the node
+func (c *NodeController) setLastSync(node *v1.Node, syncErr error) error {
+	lastSyncError := ""
+	if syncErr != nil {
+		lastSyncError = syncErr.Error()
+	}
+	return c.setNodeAnnotations(node, map[string]string{
+		AnnotationLastSyncTime:  time.Now().UTC().Format(time.RFC3339),
+		AnnotationLastSyncError: lastSyncError,
+	})
+}
+
+// changedValues returns the wanted values that differ from the current ones
+func changedValues(current map[string]string, wanted map[string]*string) map[string]*string {
+	changed := make(map[string]*string)
+	for key, value := range wanted {
+		currentValue, ok := current[key]
+		if value == nil && !ok {
+			continue
+		}
+		if value != nil && ok && currentValue == *value {
+			continue
+		}
+		changed[key] = value
+	}
+	return changed
+}
+
+// joinAnnotation returns the comma separated value of a list annotation
+func joinAnnotation(values []string) string {
+	return strings.Join(values, ",")
+}
+
+// splitAnnotation returns the values of a comma separated list annotation
+func splitAnnotation(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// recordedIDs returns the locality/id values of a list annotation matching the given id,
+// to keep them recorded when the resource could not be checked
+func recordedIDs(node *v1.Node, annotation string, id string) []string {
+	var recorded []string
+	for _, value := range splitAnnotation(node.Annotations[annotation]) {
+		if strings.HasSuffix(value, "/"+id) {
+			recorded = append(recorded, value)
+		}
+	}
+	return recorded
+}
+
+// withRecordedIDs returns the configured IDs along with the recorded ones that are not configured anymore
+func withRecordedIDs(configured []string, node *v1.Node, annotation string) []string {
+	ids := append([]string{}, configured...)
+	for _, value := range splitAnnotation(node.Annotations[annotation]) {
+		found := false
+		for _, id := range configured {
+			if value == id || strings.HasSuffix(value, "/"+id) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ids = append(ids, value)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
+	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	klog "k8s.io/klog/v2"
+)
+
+func (c *NodeController) getConfig() *config.Config {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	return c.config
+}
+
+// getNodeDNSZones returns the Scaleway DNS zones holding the node DNS domain and private domain, empty if not found
+func (c *NodeController) getNodeDNSZones() (string, string) {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	return c.nodeDNSZone, c.nodeDNSPrivateZone
+}
+
+// getDNSZone returns the Scaleway DNS zone holding the reverse domain, if any
+func (c *NodeController) getDNSZone() (string, bool) {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	return c.scwZone, c.scwZoneFound
+}
+
+func (c *NodeController) setConfig(cfg *config.Config) {
+	var zone string
+	var zoneFound bool
+	if cfg.ReverseIPDomain != "" {
+		zone, zoneFound = c.findDNSZone(cfg.ReverseIPDomain)
+	}
+
+	var nodeDNSZone, nodeDNSPrivateZone string
+	if cfg.NodeDNS.Domain != "" {
+		nodeDNSZone, _ = c.findDNSZone(cfg.NodeDNS.Domain)
+		if nodeDNSZone == "" {
+			klog.Errorf("could not find the scaleway zone of node dns domain %s", cfg.NodeDNS.Domain)
+		}
+	}
+	if cfg.NodeDNS.PrivateDomain != "" {
+		nodeDNSPrivateZone, _ = c.findDNSZone(cfg.NodeDNS.PrivateDomain)
+		if nodeDNSPrivateZone == "" {
+			klog.Errorf("could not find the scaleway zone of node dns private domain %s", cfg.NodeDNS.PrivateDomain)
+		}
+	}
+
+	reverseName, err := config.ParseReverseNameTemplate(cfg.ReverseNameTemplate)
+	if err != nil {
+		klog.Errorf("could not parse reverse name template, using the default one: %v", err)
+		reverseName, _ = config.ParseReverseNameTemplate("")
+	}
+
+	nodeSelectors := make(map[string]labels.Selector, len(cfg.NodeSelectors))
+	for feature, s := range cfg.NodeSelectors {
+		selector, err := labels.Parse(s)
+		if err != nil {
+			klog.Errorf("could not parse node selector of %s, selecting no node: %v", feature, err)
+			selector = labels.Nothing()
+		}
+		nodeSelectors[feature] = selector
+	}
+
+	ipPools := make([]reservedIPPool, 0, len(cfg.ReservedIPPools)+1)
+	for _, pool := range cfg.ReservedIPPools {
+		selector, err := pool.Selector()
+		if err != nil {
+			klog.Errorf("could not parse node selector of reserved IP pool %s, selecting no node: %v", pool.Name, err)
+			selector = labels.Nothing()
+		}
+		ipPools = append(ipPools, reservedIPPool{
+			name:          pool.Name,
+			selector:      selector,
+			ips:           pool.IPs,
+			tags:          pool.Tags,
+			autoProvision: pool.AutoProvision,
+			ownerTags:     ipOwnerTags(cfg.ClusterID, pool.Name),
+		})
+	}
+	if len(cfg.ReservedIPs) != 0 || len(cfg.ReservedIPTags) != 0 || cfg.ReservedIPAutoProvision != nil {
+		ipPools = append(ipPools, reservedIPPool{
+			name:          config.DefaultReservedIPPool,
+			selector:      labels.Everything(),
+			ips:           cfg.ReservedIPs,
+			tags:          cfg.ReservedIPTags,
+			autoProvision: cfg.ReservedIPAutoProvision,
+			ownerTags:     ipOwnerTags(cfg.ClusterID, config.DefaultReservedIPPool),
+		})
+	}
+
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	c.config = cfg
+	c.scwZone = zone
+	c.scwZoneFound = zoneFound
+	c.reverseName = reverseName
+	c.nodeDNSZone = nodeDNSZone
+	c.nodeDNSPrivateZone = nodeDNSPrivateZone
+	c.nodeSelectors = nodeSelectors
+	c.ipPools = ipPools
+}
+
+// UpdateConfig replaces the configuration and resyncs every node
+func (c *NodeController) UpdateConfig(cfg *config.Config) {
+	c.setConfig(cfg)
+	c.warnUnknownSelectors()
+
+	for _, key := range c.indexer.ListKeys() {
+		c.queue.Add(key)
+	}
+}
+
+// findDNSZone tries to find the Scaleway DNS zone holding the given domain
+func (c *NodeController) findDNSZone(domain string) (string, bool) {
+	zone := ""
+	zoneFound := false
+
+	// try to find a parent zone
+	maxPage := uint32(100)
+	dnsAPI := c.scwClient.Domain
+	listing, err := dnsAPI.ListDNSZones(&dns.ListDNSZonesRequest{PageSize: &maxPage})
+	if err != nil {
+		klog.Errorf("could not get list of scaleway zones : %v", err)
+		return "", false
+	}
+
+	for i := range listing.DNSZones {
+		if strings.HasSuffix(domain, listing.DNSZones[i].Domain) {
+			subDomain := strings.TrimRight(zone, listing.DNSZones[i].Domain)
+			if subDomain == "" || subDomain[len(subDomain)-1] == '.' {
+				z := fmt.Sprintf("%s.%s", listing.DNSZones[i].Subdomain, listing.DNSZones[i].Domain)
+				if strings.HasPrefix(z, ".") {
+					z = z[1:]
+				}
+				zoneFound = true
+				if len(zone) < len(z) {
+					zone = z
+				}
+			}
+		}
+	}
+	if zoneFound {
+		klog.Infof("found an scaleway zone %s", zone)
+	}
+
+	return zone, zoneFound
+}
+
+func (c *SvcController) getConfig() *config.Config {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	return c.config
+}
+
+func (c *SvcController) setConfig(cfg *config.Config) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	c.config = cfg
+}
+
+// UpdateConfig replaces the configuration and resyncs every service
+func (c *SvcController) UpdateConfig(cfg *config.Config) {
+	c.setConfig(cfg)
+
+	for _, obj := range c.indexer.List() {
+		svc, ok := obj.(*v1.Service)
+		if !ok || !isPublicSvc(svc) {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(svc)
+		if err == nil {
+			c.queue.Add(key)
+		}
+	}
+}
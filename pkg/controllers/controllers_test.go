@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	testPollInterval = 50 * time.Millisecond
+	testTimeout      = 10 * time.Second
+)
+
+// newTestConfig returns the default config without retries, for the failures to show up right away, and with
+// the finalizer, for the deleted nodes to be cleaned up with their annotations
+func newTestConfig() *config.Config {
+	cfg := config.Default()
+	cfg.NumberRetries = 0
+	cfg.NodeFinalizer = true
+	return cfg
+}
+
+const (
+	testStateNamespace = "kube-system"
+	testStateName      = "coffee-state"
+)
+
+// versionedTracker bumps the resource version of the objects on every write, and rejects the writes of an
+// outdated version with a conflict, like the API server. The informers can then tell the changes apart.
+type versionedTracker struct {
+	k8stesting.ObjectTracker
+
+	mu      sync.Mutex
+	version int
+}
+
+func (t *versionedTracker) nextVersion() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.version++
+	return strconv.Itoa(t.version)
+}
+
+func (t *versionedTracker) Create(gvr schema.GroupVersionResource, obj runtime.Object, ns string) error {
+	obj = obj.DeepCopyObject()
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	accessor.SetResourceVersion(t.nextVersion())
+	return t.ObjectTracker.Create(gvr, obj, ns)
+}
+
+func (t *versionedTracker) Update(gvr schema.GroupVersionResource, obj runtime.Object, ns string) error {
+	obj = obj.DeepCopyObject()
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	current, err := t.ObjectTracker.Get(gvr, ns, accessor.GetName())
+	if err != nil {
+		return err
+	}
+	currentAccessor, err := meta.Accessor(current)
+	if err != nil {
+		return err
+	}
+	if version := accessor.GetResourceVersion(); version != "" && version != currentAccessor.GetResourceVersion() {
+		return apierrors.NewConflict(gvr.GroupResource(), accessor.GetName(), fmt.Errorf("the object has been modified"))
+	}
+
+	accessor.SetResourceVersion(t.nextVersion())
+	return t.ObjectTracker.Update(gvr, obj, ns)
+}
+
+// newTestClientset returns a fake clientset whose objects get a new resource version on every write
+func newTestClientset() *k8sfake.Clientset {
+	clientset := k8sfake.NewSimpleClientset()
+	clientset.PrependReactor("*", "*", k8stesting.ObjectReaction(&versionedTracker{ObjectTracker: clientset.Tracker()}))
+	return clientset
+}
+
+// newTestNodeController returns a NodeController against the Scaleway client, with its state persisted in
+// the testStateName ConfigMap, once the nodes are created
+func newTestNodeController(t *testing.T, scwClient *scaleway.Client, cfg *config.Config, nodes ...*v1.Node) (*NodeController, kubernetes.Interface) {
+	t.Helper()
+
+	clientset := newTestClientset()
+	for _, node := range nodes {
+		_, err := clientset.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("could not create node %s: %v", node.Name, err)
+		}
+	}
+
+	c, err := NewNodeController(clientset, scwClient, cfg)
+	if err != nil {
+		t.Fatalf("could not create node controller: %v", err)
+	}
+	c.UseStateConfigMap(testStateNamespace, testStateName)
+
+	return c, clientset
+}
+
+// runNodeController runs the NodeController until the end of the test
+func runNodeController(t *testing.T, c *NodeController) {
+	stop := make(chan struct{})
+	c.Wg.Add(1)
+	go c.Run(stop)
+	t.Cleanup(func() {
+		close(stop)
+		c.Wg.Wait()
+	})
+}
+
+// startNodeController runs a NodeController against the Scaleway client until the end of the test
+func startNodeController(t *testing.T, scwClient *scaleway.Client, cfg *config.Config, nodes ...*v1.Node) kubernetes.Interface {
+	t.Helper()
+
+	c, clientset := newTestNodeController(t, scwClient, cfg, nodes...)
+	runNodeController(t, c)
+	return clientset
+}
+
+// startSvcController runs a SvcController against the Scaleway client until the end of the test
+func startSvcController(t *testing.T, scwClient *scaleway.Client, cfg *config.Config, services ...*v1.Service) kubernetes.Interface {
+	t.Helper()
+
+	clientset := newTestClientset()
+	for _, svc := range services {
+		_, err := clientset.CoreV1().Services(svc.Namespace).Create(context.Background(), svc, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("could not create service %s/%s: %v", svc.Namespace, svc.Name, err)
+		}
+	}
+
+	c, err := NewSvcController(clientset, scwClient, cfg)
+	if err != nil {
+		t.Fatalf("could not create service controller: %v", err)
+	}
+
+	stop := make(chan struct{})
+	c.Wg.Add(1)
+	go c.Run(stop)
+	t.Cleanup(func() {
+		close(stop)
+		c.Wg.Wait()
+	})
+
+	return clientset
+}
+
+// newTestNode returns a node named after its server
+func newTestNode(name string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+}
+
+// getNode returns the node from the clientset, failing the test if it is not found
+func getNode(t *testing.T, clientset kubernetes.Interface, name string) *v1.Node {
+	t.Helper()
+
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("could not get node %s: %v", name, err)
+	}
+	return node
+}
+
+// deleteNode marks the node as being deleted once the controller added its finalizer, as the fake clientset
+// does not hold the deletion of a node with finalizers
+func deleteNode(t *testing.T, clientset kubernetes.Interface, name string) {
+	t.Helper()
+
+	waitFor(t, "the finalizer of node "+name, func() bool {
+		return hasFinalizer(getNode(t, clientset, name))
+	})
+
+	node := getNode(t, clientset, name)
+	now := metav1.Now()
+	node.DeletionTimestamp = &now
+	_, err := clientset.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("could not delete node %s: %v", name, err)
+	}
+}
+
+// waitFor waits until the condition is met, failing the test with the description otherwise
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+
+	err := wait.PollImmediate(testPollInterval, testTimeout, func() (bool, error) {
+		return condition(), nil
+	})
+	if err != nil {
+		t.Fatalf("timed out waiting for %s", description)
+	}
+}
@@ -3,91 +3,36 @@ package controllers
 import (
 	"fmt"
 	"net"
-	"os"
 
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
 	rdb "github.com/scaleway/scaleway-sdk-go/api/rdb/v1"
 	"github.com/scaleway/scaleway-sdk-go/scw"
 	"k8s.io/api/core/v1"
 	klog "k8s.io/klog/v2"
 )
 
-func (c *NodeController) syncDatabaseACLs(nodeName string) error {
-	if len(c.databaseIDs) == 0 {
-		return nil
-	}
-
-	var node *v1.Node
+func (c *NodeController) syncDatabaseACLs(node *v1.Node) error {
+	nodeName := node.Name
 
 	retryOnError := false
 
-	nodeObj, exists, err := c.indexer.GetByKey(nodeName)
-	if err != nil {
-		klog.Errorf("could not get node %s by key: %v", nodeName, err)
-		return err
-	}
-	if exists {
-		var ok bool
-		node, ok = nodeObj.(*v1.Node)
-		if !ok {
-			klog.Errorf("could not get node %s from object", nodeName)
-			return fmt.Errorf("could not get node %s from object", nodeName)
-		}
-	}
+	dbAPI := c.scwClient.RDB
 
-	dbAPI := rdb.NewAPI(c.scwClient)
+	allowed := []string{}
 
-	for _, dbID := range c.databaseIDs {
+	for _, dbID := range c.getConfig().DatabaseIDs {
 		klog.Infof("whitelisting IP on node %s on database %s", nodeName, dbID)
 
-		id, region, err := getRegionalizedID(dbID)
-		if err != nil {
-			klog.Errorf("could not get id and region from %s: %v", dbID, err)
-			continue
-		}
-
-		dbInstance, err := dbAPI.GetInstance(&rdb.GetInstanceRequest{
-			Region:     scw.Region(region),
-			InstanceID: id,
-		})
-		if err != nil {
-			klog.Errorf("could not get rdb instance %s: %v", id, err)
-			continue
-		}
-
-		acls, err := dbAPI.ListInstanceACLRules(&rdb.ListInstanceACLRulesRequest{
-			Region:     dbInstance.Region,
-			InstanceID: dbInstance.ID,
-		}, scw.WithAllPages())
+		dbInstance, rule, err := c.getDatabaseACLRule(dbID, nodeName)
 		if err != nil {
-			klog.Errorf("could not get rdb acl rule for instance %s: %v", id, err)
-			continue
-		}
-
-		var rule *rdb.ACLRule
-
-		for _, acl := range acls.Rules {
-			if acl.Description == nodeName {
-				rule = acl
-				break
-			}
-		}
-
-		if !exists && rule != nil {
-			_, err := dbAPI.DeleteInstanceACLRules(&rdb.DeleteInstanceACLRulesRequest{
-				Region:     dbInstance.Region,
-				ACLRuleIPs: []string{rule.IP.String()},
-				InstanceID: dbInstance.ID,
-			})
-			if err != nil {
-				klog.Errorf("could not delete acl rule for node %s on db %s: %v", nodeName, dbInstance.ID, err)
-				retryOnError = true
-			}
+			id, _, _ := getRegionalizedID(dbID)
+			allowed = append(allowed, recordedIDs(node, AnnotationDatabases, id)...)
 			continue
 		}
 
 		var nodePublicIP net.IP
 
-		if os.Getenv(NodesIPSource) == NodesIPSourceKubernetes {
+		if c.getConfig().NodesIPSource == config.NodesIPSourceKubernetes {
 			for _, addr := range node.Status.Addresses {
 				if addr.Type == v1.NodeExternalIP {
 					// prefer ipv4 over ipv6 since RDB instances are only accessible via ipv4
@@ -99,9 +44,10 @@ func (c *NodeController) syncDatabaseACLs(nodeName string) error {
 				}
 			}
 		} else {
-			server, err := c.getInstanceFromNodeName(nodeName)
+			server, err := c.getInstanceFromNode(node)
 			if err != nil {
 				klog.Errorf("could not get instance %s: %v", nodeName, err)
+				allowed = append(allowed, recordedIDs(node, AnnotationDatabases, dbInstance.ID)...)
 				continue
 			}
 
@@ -110,18 +56,16 @@ func (c *NodeController) syncDatabaseACLs(nodeName string) error {
 				continue
 			}
 
-			nodePublicIP = server.PublicIP.Address
+			// the ACLs of the databases only accept IPv4
+			nodePublicIP = server.PublicIP.Address.To4()
 		}
 
 		if nodePublicIP == nil {
-			klog.Warningf("skipping node %s without public IP", nodeName)
+			klog.Warningf("skipping node %s without public IPv4", nodeName)
 			continue
 		}
 
-		nodeIP := net.IPNet{
-			IP:   nodePublicIP,
-			Mask: net.IPv4Mask(255, 255, 255, 255), // TODO better idea?
-		}
+		nodeIP := hostIPNet(nodePublicIP)
 
 		if rule == nil || nodeIP.String() != rule.IP.String() {
 			_, err := dbAPI.AddInstanceACLRules(&rdb.AddInstanceACLRulesRequest{
@@ -136,10 +80,67 @@ func (c *NodeController) syncDatabaseACLs(nodeName string) error {
 			})
 			if err != nil {
 				klog.Errorf("could not add acl rule for node %s with ip %s on db %s: %v", nodeName, nodeIP.String(), dbInstance.ID, err)
+				c.eventf(nodeName, v1.EventTypeWarning, EventReasonDatabaseACLFailed, "Could not add ACL rule %s on database %s/%s: %v", nodeIP.String(), dbInstance.Region, dbInstance.ID, err)
+				allowed = append(allowed, recordedIDs(node, AnnotationDatabases, dbInstance.ID)...)
 				retryOnError = true
 				continue
 			}
+			c.eventf(nodeName, v1.EventTypeNormal, EventReasonDatabaseACLAdded, "Added ACL rule %s on database %s/%s", nodeIP.String(), dbInstance.Region, dbInstance.ID)
+		}
+		allowed = append(allowed, fmt.Sprintf("%s/%s", dbInstance.Region, dbInstance.ID))
+	}
+
+	// the rules are not added in dry-run mode, so the node is left as is
+	if !c.scwClient.DryRun {
+		err := c.setNodeAnnotations(node, map[string]string{
+			AnnotationDatabases: joinAnnotation(allowed),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if retryOnError {
+		return fmt.Errorf("got retryable error")
+	}
+
+	return nil
+}
+
+func (c *NodeController) cleanupDatabaseACLs(node *v1.Node) error {
+	nodeName := node.Name
+
+	retryOnError := false
+
+	dbAPI := c.scwClient.RDB
+
+	for _, dbID := range withRecordedIDs(c.getConfig().DatabaseIDs, node, AnnotationDatabases) {
+		dbInstance, rule, err := c.getDatabaseACLRule(dbID, nodeName)
+		if err != nil {
+			// a deleted database has no rule left
+			if isNotFound(err) {
+				continue
+			}
+			c.eventf(nodeName, v1.EventTypeWarning, EventReasonDatabaseACLFailed, "Could not get ACL rules of database %s: %v", dbID, err)
+			retryOnError = true
+			continue
 		}
+		if rule == nil {
+			continue
+		}
+
+		_, err = dbAPI.DeleteInstanceACLRules(&rdb.DeleteInstanceACLRulesRequest{
+			Region:     dbInstance.Region,
+			ACLRuleIPs: []string{rule.IP.String()},
+			InstanceID: dbInstance.ID,
+		})
+		if err != nil {
+			klog.Errorf("could not delete acl rule for node %s on db %s: %v", nodeName, dbInstance.ID, err)
+			c.eventf(nodeName, v1.EventTypeWarning, EventReasonDatabaseACLFailed, "Could not remove ACL rule %s on database %s/%s: %v", rule.IP.String(), dbInstance.Region, dbInstance.ID, err)
+			retryOnError = true
+			continue
+		}
+		c.eventf(nodeName, v1.EventTypeNormal, EventReasonDatabaseACLRemoved, "Removed ACL rule %s on database %s/%s", rule.IP.String(), dbInstance.Region, dbInstance.ID)
 	}
 
 	if retryOnError {
@@ -148,3 +149,40 @@ func (c *NodeController) syncDatabaseACLs(nodeName string) error {
 
 	return nil
 }
+
+// getDatabaseACLRule returns the database and the ACL rule named after the node, if any
+func (c *NodeController) getDatabaseACLRule(dbID string, nodeName string) (*rdb.Instance, *rdb.ACLRule, error) {
+	dbAPI := c.scwClient.RDB
+
+	id, region, err := getRegionalizedID(dbID)
+	if err != nil {
+		klog.Errorf("could not get id and region from %s: %v", dbID, err)
+		return nil, nil, err
+	}
+
+	dbInstance, err := dbAPI.GetInstance(&rdb.GetInstanceRequest{
+		Region:     scw.Region(region),
+		InstanceID: id,
+	})
+	if err != nil {
+		klog.Errorf("could not get rdb instance %s: %v", id, err)
+		return nil, nil, err
+	}
+
+	acls, err := dbAPI.ListInstanceACLRules(&rdb.ListInstanceACLRulesRequest{
+		Region:     dbInstance.Region,
+		InstanceID: dbInstance.ID,
+	}, scw.WithAllPages())
+	if err != nil {
+		klog.Errorf("could not get rdb acl rule for instance %s: %v", id, err)
+		return nil, nil, err
+	}
+
+	for _, acl := range acls.Rules {
+		if acl.Description == nodeName {
+			return dbInstance, acl, nil
+		}
+	}
+
+	return dbInstance, nil, nil
+}
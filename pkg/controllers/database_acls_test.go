@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway/fake"
+)
+
+func TestDatabaseACLs(t *testing.T) {
+	b := fake.NewBackend()
+	b.AddServer("node-1", "", "51.158.1.1", "10.0.0.1")
+	b.AddServer("node-2", "", "51.158.1.2", "10.0.0.2")
+	db := b.AddDatabase("11111111-1111-4111-8111-111111111111", "")
+
+	cfg := newTestConfig()
+	cfg.DatabaseIDs = []string{db.ID}
+	clientset := startNodeController(t, b.Client(), cfg, newTestNode("node-1"), newTestNode("node-2"))
+
+	waitFor(t, "the ACL rules to be added", func() bool {
+		return len(b.DatabaseACLRules(db.ID)) == 2
+	})
+	for _, rule := range b.DatabaseACLRules(db.ID) {
+		if ip := rule.IP.String(); ip != "51.158.1.1/32" && ip != "51.158.1.2/32" {
+			t.Errorf("unexpected ACL rule %s", ip)
+		}
+	}
+	waitFor(t, "the node to be annotated", func() bool {
+		return getNode(t, clientset, "node-1").Annotations[AnnotationDatabases] != ""
+	})
+
+	deleteNode(t, clientset, "node-1")
+	waitFor(t, "the ACL rule of the node to be removed", func() bool {
+		return len(b.DatabaseACLRules(db.ID)) == 1
+	})
+	if ip := b.DatabaseACLRules(db.ID)[0].IP.String(); ip != "51.158.1.2/32" {
+		t.Errorf("expected the ACL rule of the other node to be kept, got %s", ip)
+	}
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	// dnsPropagationCheckInterval is how often a node waiting for the propagation of a record is synced again
+	dnsPropagationCheckInterval = 10 * time.Second
+	// dnsLookupTimeout bounds a single lookup
+	dnsLookupTimeout = 5 * time.Second
+)
+
+// dnsPropagation is a name a node waits to resolve to its IP
+type dnsPropagation struct {
+	name  string
+	since time.Time
+}
+
+// checkDNSPropagation returns whether the name resolves to the IP. Otherwise the node is synced again later,
+// until the name resolves or the propagation timeout is reached, in which case an error is returned.
+// It is only called by the worker.
+func (c *NodeController) checkDNSPropagation(node *v1.Node, name string, ip net.IP) (bool, error) {
+	nodeName := node.Name
+
+	resolved, err := c.resolvesTo(name, ip)
+	if err != nil {
+		klog.Warningf("could not resolve %s for node %s: %v", name, nodeName, err)
+	}
+	if resolved {
+		delete(c.dnsPending, nodeName)
+		return true, nil
+	}
+
+	if c.dnsPending == nil {
+		c.dnsPending = make(map[string]dnsPropagation)
+	}
+	pending, ok := c.dnsPending[nodeName]
+	if !ok || pending.name != name {
+		pending = dnsPropagation{name: name, since: time.Now()}
+		c.dnsPending[nodeName] = pending
+	}
+
+	timeout := c.getConfig().DNSPropagationTimeout.Duration
+	if time.Since(pending.since) >= timeout {
+		delete(c.dnsPending, nodeName)
+		klog.Errorf("%s did not resolve to %s within %s for node %s", name, ip.String(), timeout, nodeName)
+		c.eventf(nodeName, v1.EventTypeWarning, EventReasonReverseIPFailed, "DNS record %s did not resolve to %s within %s", name, ip.String(), timeout)
+		return false, fmt.Errorf("%s did not resolve to %s within %s", name, ip.String(), timeout)
+	}
+
+	klog.Infof("waiting for %s to resolve to %s for node %s", name, ip.String(), nodeName)
+	c.queue.AddAfter(nodeName, dnsPropagationCheckInterval)
+	return false, nil
+}
+
+// resolvesTo returns whether the name resolves to the IP with the configured resolver, the system one by default
+func (c *NodeController) resolvesTo(name string, ip net.IP) (bool, error) {
+	resolver := net.DefaultResolver
+	if address := c.getConfig().DNSResolver; address != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, address)
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+
+	ips, err := resolver.LookupIP(ctx, "ip", name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, resolved := range ips {
+		if resolved.Equal(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	v1 "k8s.io/api/core/v1"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	// dnsRecordTTL is the TTL of the records of the nodes
+	dnsRecordTTL = 600
+	// dnsClusterCommentPrefix starts the comments of the records owned by a cluster with an ID
+	dnsClusterCommentPrefix = "k8s cluster "
+)
+
+// nodeDNSRecord is a record wanted for a node
+type nodeDNSRecord struct {
+	zone string
+	name string
+	ip   net.IP
+}
+
+// dnsCommentPrefix returns the prefix of the comments of the records of a kind, dnsRecordCommentPrefix or
+// nodeDNSCommentPrefix, owned by the cluster. Without cluster ID, the prefix of the kind is used as is.
+func (c *NodeController) dnsCommentPrefix(kind string) string {
+	clusterID := c.getConfig().ClusterID
+	if clusterID == "" {
+		return kind
+	}
+	return dnsClusterCommentPrefix + clusterID + " " + strings.TrimPrefix(kind, "k8s ")
+}
+
+// dnsRecordNode returns the node of a record of the kind, if the cluster owns it
+func (c *NodeController) dnsRecordNode(record *dns.Record, kind string) (string, bool) {
+	prefix := c.dnsCommentPrefix(kind)
+	if record.Comment == nil || !strings.HasPrefix(*record.Comment, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(*record.Comment, prefix), true
+}
+
+// listAddressRecords returns the A and AAAA records of the zone
+func (c *NodeController) listAddressRecords(zone string) ([]*dns.Record, error) {
+	listing, err := c.scwClient.Domain.ListDNSZoneRecords(&dns.ListDNSZoneRecordsRequest{
+		DNSZone: zone,
+	}, scw.WithAllPages())
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*dns.Record{}
+	for _, record := range listing.Records {
+		if record.Type == dns.RecordTypeA || record.Type == dns.RecordTypeAAAA {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// listOwnedRecords returns the A and AAAA records of the kind owned by the cluster in the zone
+func (c *NodeController) listOwnedRecords(zone string, kind string) ([]*dns.Record, error) {
+	records, err := c.listAddressRecords(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := []*dns.Record{}
+	for _, record := range records {
+		if _, ok := c.dnsRecordNode(record, kind); ok {
+			owned = append(owned, record)
+		}
+	}
+	return owned, nil
+}
+
+// updateNodeRecords makes the records of the kind owned by the node in the zone the wanted ones, in a single
+// update: a Set change for each wanted record, keeping a single record per name and type, and a Delete
+// change for each other record of the node. The records of the node commented without cluster ID are
+// its records from before the cluster ID was set: one is adopted for each wanted name and type, and the
+// other ones are deleted.
+// A wanted name having a record owned by someone else is left untouched and fails the update.
+// The wanted records of the other zones are ignored. It returns the removed records.
+func (c *NodeController) updateNodeRecords(nodeName string, zone string, kind string, wanted []nodeDNSRecord, failedReason string) ([]*dns.Record, error) {
+	comment := c.dnsCommentPrefix(kind) + nodeName
+	legacyComment := kind + nodeName
+
+	records, err := c.listAddressRecords(zone)
+	if err != nil {
+		klog.Errorf("could not list records dns of zone %s for node %s: %v", zone, nodeName, err)
+		return nil, err
+	}
+
+	owned := []*dns.Record{}
+	legacy := []*dns.Record{}
+	others := []*dns.Record{}
+	for _, record := range records {
+		switch {
+		case record.Comment != nil && *record.Comment == comment:
+			owned = append(owned, record)
+		case record.Comment != nil && *record.Comment == legacyComment:
+			legacy = append(legacy, record)
+		default:
+			others = append(others, record)
+		}
+	}
+
+	kept := make(map[string]bool)
+	sets := []*dns.RecordChange{}
+	setRecords := []*dns.Record{}
+	conflicts := []string{}
+	for _, w := range wanted {
+		if w.zone != zone {
+			continue
+		}
+		recordType := dnsRecordType(w.ip)
+		current := matchingRecords(owned, zone, w.name, recordType)
+		if len(current) == 0 {
+			current = matchingRecords(legacy, zone, w.name, recordType)
+		}
+		if len(current) == 1 && *current[0].Comment == comment && w.ip.Equal(net.ParseIP(current[0].Data)) {
+			kept[current[0].ID] = true
+			continue
+		}
+
+		record := &dns.Record{
+			Data:    w.ip.String(),
+			Name:    w.name + ".",
+			TTL:     dnsRecordTTL,
+			Type:    recordType,
+			Comment: &comment,
+		}
+		set := &dns.RecordChangeSet{Records: []*dns.Record{record}}
+		if len(current) != 0 {
+			// the other duplicates are deleted along with the stale records, legacy ones included
+			kept[current[0].ID] = true
+			set.ID = &current[0].ID
+		} else {
+			if len(matchingRecords(others, zone, w.name, recordType)) != 0 {
+				conflicts = append(conflicts, w.name)
+				continue
+			}
+			set.IDFields = &dns.RecordIdentifier{
+				Name: record.Name,
+				Type: recordType,
+			}
+		}
+		sets = append(sets, &dns.RecordChange{Set: set})
+		setRecords = append(setRecords, record)
+	}
+
+	changes := []*dns.RecordChange{}
+	removed := []*dns.Record{}
+	for _, record := range append(owned, legacy...) {
+		if kept[record.ID] {
+			continue
+		}
+		changes = append(changes, &dns.RecordChange{
+			Delete: &dns.RecordChangeDelete{
+				ID: &record.ID,
+			},
+		})
+		removed = append(removed, record)
+	}
+	changes = append(changes, sets...)
+
+	if len(changes) != 0 {
+		_, err = c.scwClient.Domain.UpdateDNSZoneRecords(&dns.UpdateDNSZoneRecordsRequest{
+			DNSZone: zone,
+			Changes: changes,
+		})
+		if err != nil {
+			klog.Errorf("could not update records dns of zone %s for node %s: %v", zone, nodeName, err)
+			c.eventf(nodeName, v1.EventTypeWarning, failedReason, "Could not update DNS records in zone %s: %v", zone, err)
+			return nil, err
+		}
+
+		for _, record := range removed {
+			c.eventf(nodeName, v1.EventTypeNormal, EventReasonDNSRecordRemoved, "Removed DNS record %s for IP %s in zone %s", strings.TrimSuffix(record.Name, "."), record.Data, zone)
+		}
+		for _, record := range setRecords {
+			c.eventf(nodeName, v1.EventTypeNormal, EventReasonDNSRecordAdded, "Set DNS record %s for IP %s in zone %s", strings.TrimSuffix(record.Name, "."), record.Data, zone)
+		}
+	}
+
+	if len(conflicts) != 0 {
+		klog.Errorf("could not set records dns %s of zone %s for node %s: owned by someone else", strings.Join(conflicts, ", "), zone, nodeName)
+		c.eventf(nodeName, v1.EventTypeWarning, failedReason, "DNS records %s in zone %s are owned by someone else", strings.Join(conflicts, ", "), zone)
+		return removed, fmt.Errorf("records dns %s of zone %s are owned by someone else", strings.Join(conflicts, ", "), zone)
+	}
+	return removed, nil
+}
+
+// matchingRecords returns the records with the given name and type
+func matchingRecords(records []*dns.Record, zone string, name string, recordType dns.RecordType) []*dns.Record {
+	matching := []*dns.Record{}
+	for _, record := range records {
+		if record.Type == recordType && isRecordName(record.Name, zone, name) {
+			matching = append(matching, record)
+		}
+	}
+	return matching
+}
+
+// isRecordName returns whether the name of a record of the zone, either relative to the zone or fully qualified, is name
+func isRecordName(recordName string, zone string, name string) bool {
+	recordName = strings.TrimSuffix(recordName, ".")
+	name = strings.TrimSuffix(name, ".")
+	return recordName == name || recordName+"."+zone == name || (recordName == "" && zone == name)
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDryRun(t *testing.T) {
+	b := fake.NewBackend()
+	b.AddServer("node-1", "", "51.158.1.1", "10.0.0.1")
+	ip := b.AddIP("51.15.15.15", "")
+	db := b.AddDatabase("11111111-1111-4111-8111-111111111111", "")
+	b.AddSecurityGroup(testSecurityGroupID, "")
+
+	cfg := newTestConfig()
+	cfg.ReservedIPs = []string{ip.Address.String()}
+	cfg.DatabaseIDs = []string{db.ID}
+	cfg.SecurityGroupIDs = []string{testSecurityGroupID}
+	plan := scaleway.NewPlan(100)
+	clientset := startNodeController(t, scaleway.WithDryRun(b.Client(), plan), cfg, newTestNode("node-1"))
+
+	waitFor(t, "the changes to be planned", func() bool {
+		planned := make(map[string]bool)
+		for _, action := range plan.Actions() {
+			planned[action.Method] = true
+		}
+		return planned["UpdateIP"] && planned["AddInstanceACLRules"] && planned["CreateSecurityGroupRule"]
+	})
+	waitFor(t, "the sync to be recorded", func() bool {
+		return getNode(t, clientset, "node-1").Annotations[AnnotationLastSyncTime] != ""
+	})
+
+	if b.IP(ip.ID).Server != nil || len(b.DatabaseACLRules(db.ID)) != 0 || len(b.SecurityGroupRules(testSecurityGroupID)) != 0 {
+		t.Errorf("expected nothing to change on Scaleway")
+	}
+
+	node := getNode(t, clientset, "node-1")
+	if hasFinalizer(node) {
+		t.Errorf("expected no finalizer")
+	}
+	if node.Labels[NodeLabelReservedIP] != "" {
+		t.Errorf("expected no reserved IP label")
+	}
+	for _, annotation := range []string{AnnotationReservedIPID, AnnotationDatabases, AnnotationSecurityGroups} {
+		if value, ok := node.Annotations[annotation]; ok {
+			t.Errorf("expected no %s annotation, got %q", annotation, value)
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == TaintReservedIPPending {
+			t.Errorf("expected no %s taint", TaintReservedIPPending)
+		}
+	}
+
+	state, err := clientset.CoreV1().ConfigMaps(testStateNamespace).Get(context.Background(), testStateName, metav1.GetOptions{})
+	if err == nil && len(state.Data) != 0 {
+		t.Errorf("expected no managed node, got %v", state.Data)
+	}
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	eventComponent = "scaleway-k8s-node-coffee"
+
+	EventReasonReservedIPAttached      = "ReservedIPAttached"
+	EventReasonReservedIPDetached      = "ReservedIPDetached"
+	EventReasonReservedIPProvisioned   = "ReservedIPProvisioned"
+	EventReasonReservedIPFailed        = "ReservedIPFailed"
+	EventReasonReservedIPPoolExhausted = "ReservedIPPoolExhausted"
+
+	EventReasonReverseIPUpdated = "ReverseIPUpdated"
+	EventReasonReverseIPRemoved = "ReverseIPRemoved"
+	EventReasonReverseIPFailed  = "ReverseIPFailed"
+	EventReasonDNSRecordAdded   = "DNSRecordAdded"
+	EventReasonDNSRecordRemoved = "DNSRecordRemoved"
+	EventReasonNodeDNSFailed    = "NodeDNSFailed"
+
+	EventReasonDatabaseACLAdded   = "DatabaseACLAdded"
+	EventReasonDatabaseACLRemoved = "DatabaseACLRemoved"
+	EventReasonDatabaseACLFailed  = "DatabaseACLFailed"
+
+	EventReasonRedisACLAdded   = "RedisACLAdded"
+	EventReasonRedisACLRemoved = "RedisACLRemoved"
+	EventReasonRedisACLFailed  = "RedisACLFailed"
+
+	EventReasonSecurityGroupRuleAdded   = "SecurityGroupRuleAdded"
+	EventReasonSecurityGroupRuleRemoved = "SecurityGroupRuleRemoved"
+	EventReasonSecurityGroupRuleFailed  = "SecurityGroupRuleFailed"
+
+	EventReasonZoneMismatch = "ZoneMismatch"
+)
+
+func newEventRecorder(clientset kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(klog.V(4).Infof)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventComponent})
+}
+
+// nodeRef references a node the same way the kubelet does, so that the events show up in kubectl describe node.
+// It only needs the node name, and thus works for deleted nodes too.
+func nodeRef(nodeName string) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		Kind: "Node",
+		Name: nodeName,
+		UID:  types.UID(nodeName),
+	}
+}
+
+func (c *NodeController) eventf(nodeName string, eventType string, reason string, messageFmt string, args ...interface{}) {
+	c.recorder.Eventf(nodeRef(nodeName), eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	// NodeFinalizer holds the deletion of a node until its cloud state has been cleaned up
+	NodeFinalizer = "coffee.scaleway.com/cleanup"
+)
+
+// finalizersPatch is a merge patch of the finalizers, failing if the node changed in between
+type finalizersPatch struct {
+	Metadata struct {
+		Finalizers      []string `json:"finalizers"`
+		ResourceVersion string   `json:"resourceVersion"`
+	} `json:"metadata"`
+}
+
+func hasFinalizer(node *v1.Node) bool {
+	return stringInSlice(NodeFinalizer, node.Finalizers)
+}
+
+// ensureFinalizer adds or removes the finalizer on a node not being deleted, depending on the config
+func (c *NodeController) ensureFinalizer(node *v1.Node) error {
+	// nothing is created in dry-run mode, so there is nothing to hold the deletion for
+	if c.scwClient.DryRun || c.getConfig().NodeFinalizer == hasFinalizer(node) {
+		return nil
+	}
+
+	finalizers := []string{}
+	for _, f := range node.Finalizers {
+		if f != NodeFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	if c.getConfig().NodeFinalizer {
+		finalizers = append(finalizers, NodeFinalizer)
+	}
+
+	return c.patchFinalizers(node, finalizers)
+}
+
+// removeFinalizer lets the deletion of the node go on
+func (c *NodeController) removeFinalizer(node *v1.Node) error {
+	finalizers := []string{}
+	for _, f := range node.Finalizers {
+		if f != NodeFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+
+	klog.Infof("cleanup done for node %s, removing finalizer", node.Name)
+	return c.patchFinalizers(node, finalizers)
+}
+
+func (c *NodeController) patchFinalizers(node *v1.Node, finalizers []string) error {
+	patch := finalizersPatch{}
+	patch.Metadata.Finalizers = finalizers
+	patch.Metadata.ResourceVersion = node.ResourceVersion
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.clientset.CoreV1().Nodes().Patch(context.Background(), node.Name, types.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		klog.Errorf("could not patch finalizers of node %s: %v", node.Name, err)
+		return err
+	}
+	return nil
+}
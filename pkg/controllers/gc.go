@@ -0,0 +1,458 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/metrics"
+	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
+	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	rdb "github.com/scaleway/scaleway-sdk-go/api/rdb/v1"
+	redis "github.com/scaleway/scaleway-sdk-go/api/redis/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	v1 "k8s.io/api/core/v1"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	// gcDisabledCheckInterval is how often a disabled garbage collection checks whether it got enabled
+	gcDisabledCheckInterval = time.Minute
+
+	dnsRecordCommentPrefix = "k8s node "
+)
+
+// runGarbageCollector periodically removes the resources owned by the nodes that are gone, until stopCh is closed
+func (c *NodeController) runGarbageCollector(stopCh <-chan struct{}) {
+	for {
+		interval := c.getConfig().GarbageCollection.Interval.Duration
+		if interval == 0 {
+			interval = gcDisabledCheckInterval
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(interval):
+		}
+
+		if c.getConfig().GarbageCollection.Interval.Duration == 0 {
+			continue
+		}
+
+		err := c.collectGarbage()
+		if err != nil {
+			klog.Errorf("garbage collection failed: %v", err)
+		}
+	}
+}
+
+// collectGarbage removes the ACL rules, security group rules and DNS records of the nodes gone
+// for longer than the grace period. Only the resources provably owned by the controller are removed:
+// the ACL and security group rules of the nodes persisted as managed, and their DNS records along with
+// the ones commented after a node of the cluster, when it has an ID to tell them from other clusters.
+func (c *NodeController) collectGarbage() error {
+	cfg := c.getConfig()
+
+	managed, err := c.managedNodes()
+	if err != nil {
+		return fmt.Errorf("could not get managed nodes: %v", err)
+	}
+
+	currentNodes := make(map[string]bool)
+	currentIPs := make(map[string]bool)
+	for _, obj := range c.indexer.List() {
+		node, ok := obj.(*v1.Node)
+		if !ok {
+			continue
+		}
+		currentNodes[node.Name] = true
+		for _, addr := range node.Status.Addresses {
+			currentIPs[addr.Address] = true
+		}
+		for _, ip := range managed[node.Name].IPs {
+			currentIPs[ip] = true
+		}
+	}
+
+	records, err := c.listNodeRecords()
+	if err != nil {
+		return fmt.Errorf("could not list dns records: %v", err)
+	}
+
+	nodeDNSRecords, err := c.listNodeDNSZonesRecords()
+	if err != nil {
+		return fmt.Errorf("could not list node dns records: %v", err)
+	}
+
+	missing := make(map[string]bool)
+	for name := range managed {
+		if name != "" && !currentNodes[name] {
+			missing[name] = true
+		}
+	}
+	// without cluster ID, the records of the other clusters sharing the zone have the same comments
+	if cfg.ClusterID != "" {
+		for _, record := range records {
+			c.addMissingRecordNode(missing, currentNodes, record, dnsRecordCommentPrefix)
+		}
+		for _, zoneRecords := range nodeDNSRecords {
+			for _, record := range zoneRecords {
+				c.addMissingRecordNode(missing, currentNodes, record, nodeDNSCommentPrefix)
+			}
+		}
+	}
+
+	if c.gcMissingSince == nil {
+		c.gcMissingSince = make(map[string]time.Time)
+	}
+	for name := range c.gcMissingSince {
+		if !missing[name] {
+			delete(c.gcMissingSince, name)
+		}
+	}
+
+	now := time.Now()
+	gone := make(map[string]bool)
+	for name := range missing {
+		since, ok := c.gcMissingSince[name]
+		if !ok {
+			c.gcMissingSince[name] = now
+			since = now
+		}
+		if now.Sub(since) >= cfg.GarbageCollection.GracePeriod.Duration {
+			gone[name] = true
+		}
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+
+	// the ACL rules are only told apart by the node name in their description, so only the ones
+	// of the managed nodes are removed
+	goneManaged := make(map[string]bool)
+	goneIPs := make(map[string]bool)
+	for name := range gone {
+		node, ok := managed[name]
+		if !ok {
+			continue
+		}
+		goneManaged[name] = true
+		for _, ip := range node.IPs {
+			if !currentIPs[ip] {
+				goneIPs[ip] = true
+			}
+		}
+	}
+
+	klog.Infof("collecting the resources of %d nodes gone", len(gone))
+
+	gotErr := false
+	for _, collect := range []func() error{
+		func() error { return c.collectDatabaseACLs(goneManaged, currentIPs) },
+		func() error { return c.collectRedisACLs(goneManaged) },
+		func() error { return c.collectSecurityGroupRules(goneIPs) },
+		func() error { return c.collectDNSRecords(records, gone, managed, currentIPs) },
+		func() error { return c.collectNodeDNSRecords(nodeDNSRecords, gone) },
+	} {
+		if err := collect(); err != nil {
+			gotErr = true
+		}
+	}
+	if gotErr {
+		return fmt.Errorf("got some errors")
+	}
+
+	// nothing was removed in dry-run mode, the nodes are collected again once it is disabled
+	if c.scwClient.DryRun {
+		return nil
+	}
+
+	names := make([]string, 0, len(gone))
+	for name := range gone {
+		names = append(names, name)
+		delete(c.gcMissingSince, name)
+	}
+	return c.forgetManagedNodes(names...)
+}
+
+// addMissingRecordNode adds the node of the record owned by the cluster to missing, if it is not a current node
+func (c *NodeController) addMissingRecordNode(missing map[string]bool, currentNodes map[string]bool, record *dns.Record, kind string) {
+	name, ok := c.dnsRecordNode(record, kind)
+	if !ok || name == "" || currentNodes[name] {
+		return
+	}
+	missing[name] = true
+}
+
+func (c *NodeController) collectDatabaseACLs(gone map[string]bool, currentIPs map[string]bool) error {
+	dbAPI := c.scwClient.RDB
+	gotErr := false
+
+	for _, dbID := range c.getConfig().DatabaseIDs {
+		id, region, err := getRegionalizedID(dbID)
+		if err != nil {
+			klog.Errorf("could not get id and region from %s: %v", dbID, err)
+			gotErr = true
+			continue
+		}
+
+		acls, err := dbAPI.ListInstanceACLRules(&rdb.ListInstanceACLRulesRequest{
+			Region:     scw.Region(region),
+			InstanceID: id,
+		}, scw.WithAllPages())
+		if err != nil {
+			klog.Errorf("could not get rdb acl rule for instance %s: %v", id, err)
+			gotErr = true
+			continue
+		}
+
+		for _, rule := range acls.Rules {
+			// rules are deleted by IP, do not remove the one of a current node
+			if !gone[rule.Description] || currentIPs[rule.IP.IP.String()] {
+				continue
+			}
+
+			_, err := dbAPI.DeleteInstanceACLRules(&rdb.DeleteInstanceACLRulesRequest{
+				Region:     scw.Region(region),
+				InstanceID: id,
+				ACLRuleIPs: []string{rule.IP.String()},
+			})
+			if err != nil {
+				klog.Errorf("could not delete acl rule of gone node %s on db %s: %v", rule.Description, id, err)
+				gotErr = true
+				continue
+			}
+			klog.Infof("deleted acl rule %s of gone node %s on db %s", rule.IP.String(), rule.Description, id)
+			metrics.GarbageCollectedTotal.WithLabelValues("database-acl").Inc()
+		}
+	}
+
+	if gotErr {
+		return fmt.Errorf("got some errors")
+	}
+	return nil
+}
+
+func (c *NodeController) collectRedisACLs(gone map[string]bool) error {
+	redisAPI := c.scwClient.Redis
+	gotErr := false
+
+	for _, redisID := range c.getConfig().RedisIDs {
+		id, zone, err := getRegionalizedID(redisID)
+		if err != nil {
+			klog.Errorf("could not get id and zone from %s: %v", redisID, err)
+			gotErr = true
+			continue
+		}
+
+		cluster, err := redisAPI.GetCluster(&redis.GetClusterRequest{
+			Zone:      scw.Zone(zone),
+			ClusterID: id,
+		})
+		if err != nil {
+			klog.Errorf("could not get redis instance %s: %v", id, err)
+			gotErr = true
+			continue
+		}
+
+		for _, rule := range cluster.ACLRules {
+			if rule.Description == nil || !gone[*rule.Description] {
+				continue
+			}
+
+			_, err := redisAPI.DeleteACLRule(&redis.DeleteACLRuleRequest{
+				Zone:  cluster.Zone,
+				ACLID: rule.ID,
+			})
+			if err != nil {
+				klog.Errorf("could not delete acl rule of gone node %s on redis instance %s: %v", *rule.Description, id, err)
+				gotErr = true
+				continue
+			}
+			klog.Infof("deleted acl rule %s of gone node %s on redis instance %s", rule.ID, *rule.Description, id)
+			metrics.GarbageCollectedTotal.WithLabelValues("redis-acl").Inc()
+		}
+	}
+
+	if gotErr {
+		return fmt.Errorf("got some errors")
+	}
+	return nil
+}
+
+// collectSecurityGroupRules removes the rules allowing the IPs of the gone nodes, as created by updateSecurityGroups
+func (c *NodeController) collectSecurityGroupRules(goneIPs map[string]bool) error {
+	if len(goneIPs) == 0 {
+		return nil
+	}
+
+	instanceAPI := c.scwClient.Instance
+	gotErr := false
+
+	for _, id := range c.getConfig().SecurityGroupIDs {
+		sgID, zone, err := getZonalID(id)
+		if err != nil {
+			klog.Errorf("could not get id and zone from %s: %v", id, err)
+			gotErr = true
+			continue
+		}
+
+		sgRulesResp, err := instanceAPI.ListSecurityGroupRules(&instance.ListSecurityGroupRulesRequest{
+			SecurityGroupID: sgID,
+			Zone:            scw.Zone(zone),
+		}, scw.WithAllPages())
+		if err != nil {
+			klog.Errorf("could not list rules for security group %s: %v", sgID, err)
+			gotErr = true
+			continue
+		}
+
+		for _, sgRule := range sgRulesResp.Rules {
+			ones, bits := sgRule.IPRange.Mask.Size()
+			if !goneIPs[sgRule.IPRange.IP.String()] || ones != bits ||
+				sgRule.Action != instance.SecurityGroupRuleActionAccept ||
+				sgRule.Direction != instance.SecurityGroupRuleDirectionInbound ||
+				sgRule.Protocol != instance.SecurityGroupRuleProtocolANY {
+				continue
+			}
+
+			err := instanceAPI.DeleteSecurityGroupRule(&instance.DeleteSecurityGroupRuleRequest{
+				Zone:                scw.Zone(zone),
+				SecurityGroupID:     sgID,
+				SecurityGroupRuleID: sgRule.ID,
+			})
+			if err != nil {
+				klog.Errorf("could not delete security group rule %s for SG %s: %v", sgRule.ID, sgID, err)
+				gotErr = true
+				continue
+			}
+			klog.Infof("deleted security group rule %s of gone IP %s on %s", sgRule.ID, sgRule.IPRange.IP.String(), sgID)
+			metrics.GarbageCollectedTotal.WithLabelValues("security-group-rule").Inc()
+		}
+	}
+
+	if gotErr {
+		return fmt.Errorf("got some errors")
+	}
+	return nil
+}
+
+// collectDNSRecords removes the DNS records of the gone nodes and the reverse of their IPs,
+// looked up in the zone recorded for the node, the default one otherwise
+func (c *NodeController) collectDNSRecords(records []*dns.Record, gone map[string]bool, managed map[string]managedNode, currentIPs map[string]bool) error {
+	scwZone, _ := c.getDNSZone()
+	dnsAPI := c.scwClient.Domain
+	instanceAPI := c.scwClient.Instance
+	gotErr := false
+
+	for _, record := range records {
+		nodeName, ok := c.dnsRecordNode(record, dnsRecordCommentPrefix)
+		if !ok || !gone[nodeName] {
+			continue
+		}
+
+		_, err := dnsAPI.UpdateDNSZoneRecords(&dns.UpdateDNSZoneRecordsRequest{
+			DNSZone: scwZone,
+			Changes: []*dns.RecordChange{
+				{
+					Delete: &dns.RecordChangeDelete{
+						ID: &record.ID,
+					},
+				},
+			},
+		})
+		if err != nil {
+			klog.Errorf("could not delete record dns of gone node %s: %v", nodeName, err)
+			gotErr = true
+			continue
+		}
+		klog.Infof("deleted record dns %s of gone node %s", record.Name, nodeName)
+		metrics.GarbageCollectedTotal.WithLabelValues("dns-record").Inc()
+
+		// only the reserved IPv4 have a reverse, the records of the IPv6 are just removed
+		if currentIPs[record.Data] || record.Type != dns.RecordTypeA {
+			continue
+		}
+		_, err = instanceAPI.UpdateIP(&instance.UpdateIPRequest{
+			Zone:    scw.Zone(managed[nodeName].Zone),
+			IP:      record.Data,
+			Reverse: &instance.NullableStringValue{Null: true},
+		})
+		if err != nil && !isNotFound(err) {
+			klog.Errorf("could not remove reverse of IP %s of gone node %s: %v", record.Data, nodeName, err)
+			gotErr = true
+		}
+	}
+
+	if gotErr {
+		return fmt.Errorf("got some errors")
+	}
+	return nil
+}
+
+// collectNodeDNSRecords removes the node DNS records of the gone nodes, by zone
+func (c *NodeController) collectNodeDNSRecords(records map[string][]*dns.Record, gone map[string]bool) error {
+	dnsAPI := c.scwClient.Domain
+	gotErr := false
+
+	for zone, zoneRecords := range records {
+		for _, record := range zoneRecords {
+			nodeName, ok := c.dnsRecordNode(record, nodeDNSCommentPrefix)
+			if !ok || !gone[nodeName] {
+				continue
+			}
+
+			_, err := dnsAPI.UpdateDNSZoneRecords(&dns.UpdateDNSZoneRecordsRequest{
+				DNSZone: zone,
+				Changes: []*dns.RecordChange{
+					{
+						Delete: &dns.RecordChangeDelete{
+							ID: &record.ID,
+						},
+					},
+				},
+			})
+			if err != nil {
+				klog.Errorf("could not delete node record dns of gone node %s: %v", nodeName, err)
+				gotErr = true
+				continue
+			}
+			klog.Infof("deleted node record dns %s of gone node %s", record.Name, nodeName)
+			metrics.GarbageCollectedTotal.WithLabelValues("node-dns-record").Inc()
+		}
+	}
+
+	if gotErr {
+		return fmt.Errorf("got some errors")
+	}
+	return nil
+}
+
+// listNodeDNSZonesRecords returns the node DNS records of the node DNS zones found on Scaleway, by zone
+func (c *NodeController) listNodeDNSZonesRecords() (map[string][]*dns.Record, error) {
+	records := make(map[string][]*dns.Record)
+	zone, privateZone := c.getNodeDNSZones()
+	for _, z := range []string{zone, privateZone} {
+		if z == "" {
+			continue
+		}
+		if _, ok := records[z]; ok {
+			continue
+		}
+		zoneRecords, err := c.listNodeDNSRecords(z)
+		if err != nil {
+			return nil, err
+		}
+		records[z] = zoneRecords
+	}
+	return records, nil
+}
+
+// listNodeRecords returns the A and AAAA records of the reverse DNS zone owned by the cluster, if the zone is on Scaleway
+func (c *NodeController) listNodeRecords() ([]*dns.Record, error) {
+	scwZone, scwZoneFound := c.getDNSZone()
+	if !scwZoneFound {
+		return nil, nil
+	}
+	return c.listOwnedRecords(scwZone, dnsRecordCommentPrefix)
+}
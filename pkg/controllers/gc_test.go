@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway/fake"
+	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
+	rdb "github.com/scaleway/scaleway-sdk-go/api/rdb/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newGCTestController returns a NodeController that is not running, for the garbage collection to see
+// every node as gone, with the given nodes recorded as managed
+func newGCTestController(t *testing.T, b *fake.Backend, cfg *config.Config, managed ...*v1.Node) *NodeController {
+	t.Helper()
+
+	cfg.GarbageCollection.Interval = metav1.Duration{Duration: 1}
+	cfg.GarbageCollection.GracePeriod = metav1.Duration{}
+	c, err := NewNodeController(newTestClientset(), b.Client(), cfg)
+	if err != nil {
+		t.Fatalf("could not create node controller: %v", err)
+	}
+	c.UseStateConfigMap(testStateNamespace, testStateName)
+	for _, node := range managed {
+		err := c.recordManagedNode(node)
+		if err != nil {
+			t.Fatalf("could not record node %s: %v", node.Name, err)
+		}
+	}
+	return c
+}
+
+// addDatabaseACLRules adds a rule per IP, described by the given name
+func addDatabaseACLRules(t *testing.T, b *fake.Backend, id string, rules map[string]string) {
+	t.Helper()
+
+	for ip, description := range rules {
+		_, err := b.Client().RDB.AddInstanceACLRules(&rdb.AddInstanceACLRulesRequest{
+			InstanceID: id,
+			Rules: []*rdb.ACLRuleRequest{
+				{
+					IP:          scw.IPNet{IPNet: hostIPNet(net.ParseIP(ip))},
+					Description: description,
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("could not add ACL rule %s: %v", ip, err)
+		}
+	}
+}
+
+// addDNSRecords adds an A record per name, commented with the given comment
+func addDNSRecords(t *testing.T, b *fake.Backend, zone string, records map[string]string) {
+	t.Helper()
+
+	for name, comment := range records {
+		comment := comment
+		_, err := b.Client().Domain.UpdateDNSZoneRecords(&dns.UpdateDNSZoneRecordsRequest{
+			DNSZone: zone,
+			Changes: []*dns.RecordChange{
+				{
+					Add: &dns.RecordChangeAdd{
+						Records: []*dns.Record{
+							{Name: name + ".", Type: dns.RecordTypeA, Data: "192.0.2.1", TTL: dnsRecordTTL, Comment: &comment},
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("could not add record %s: %v", name, err)
+		}
+	}
+}
+
+// databaseACLDescriptions returns the descriptions of the ACL rules of the database, by IP
+func databaseACLDescriptions(b *fake.Backend, id string) map[string]string {
+	rules := make(map[string]string)
+	for _, rule := range b.DatabaseACLRules(id) {
+		rules[rule.IP.IP.String()] = rule.Description
+	}
+	return rules
+}
+
+// dnsRecordComments returns the comments of the records of the zone, by name
+func dnsRecordComments(b *fake.Backend, zone string) map[string]string {
+	comments := make(map[string]string)
+	for _, record := range b.DNSRecords(zone) {
+		comments[record.Name] = *record.Comment
+	}
+	return comments
+}
+
+func TestCollectGarbage(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		clusterID string
+		records   map[string]string
+		// wantedRecords are the names of the records left after the collection
+		wantedRecords []string
+	}{
+		{
+			name: "without cluster ID",
+			records: map[string]string{
+				"node-1.example.com": "k8s node node-1",
+				"node-2.example.com": "k8s node node-2",
+			},
+			// node-2 may be a node of another cluster sharing the zone
+			wantedRecords: []string{"node-2.example.com."},
+		},
+		{
+			name:      "with cluster ID",
+			clusterID: "test",
+			records: map[string]string{
+				"node-1.example.com": "k8s cluster test node node-1",
+				"node-2.example.com": "k8s cluster test node node-2",
+				"node-3.example.com": "k8s cluster other node node-3",
+				"empty.example.com":  "k8s cluster test node ",
+			},
+			wantedRecords: []string{"node-3.example.com.", "empty.example.com."},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := fake.NewBackend()
+			db := b.AddDatabase("11111111-1111-4111-8111-111111111111", "")
+			b.AddDNSZone("example.com", "")
+			addDNSRecords(t, b, "example.com", tt.records)
+			addDatabaseACLRules(t, b, db.ID, map[string]string{
+				"51.158.1.1":   "node-1",
+				"51.158.1.2":   "node-2",
+				"198.51.100.1": "",
+			})
+
+			cfg := newTestConfig()
+			cfg.ClusterID = tt.clusterID
+			cfg.ReverseIPDomain = "example.com"
+			cfg.DatabaseIDs = []string{db.ID}
+			node := newTestNode("node-1")
+			node.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "51.158.1.1"}}
+			c := newGCTestController(t, b, cfg, node)
+
+			err := c.collectGarbage()
+			if err != nil {
+				t.Fatalf("garbage collection failed: %v", err)
+			}
+
+			// only the rule of the managed node is removed, node-2 is not recorded in the state
+			rules := databaseACLDescriptions(b, db.ID)
+			if _, ok := rules["51.158.1.1"]; ok || len(rules) != 2 {
+				t.Errorf("expected only the ACL rule of node-1 to be removed, got %v", rules)
+			}
+
+			comments := dnsRecordComments(b, "example.com")
+			if len(comments) != len(tt.wantedRecords) {
+				t.Errorf("expected records %v, got %v", tt.wantedRecords, comments)
+			}
+			for _, name := range tt.wantedRecords {
+				if _, ok := comments[name]; !ok {
+					t.Errorf("expected record %s to be kept, got %v", name, comments)
+				}
+			}
+
+			managed, err := c.managedNodes()
+			if err != nil {
+				t.Fatalf("could not get managed nodes: %v", err)
+			}
+			if len(managed) != 0 {
+				t.Errorf("expected the gone nodes to be forgotten, got %v", managed)
+			}
+		})
+	}
+}
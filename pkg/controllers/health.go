@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// workerStallTimeout is how long a worker can spend on a key, or leave a non empty queue untouched, before being reported unhealthy
+	workerStallTimeout = 5 * time.Minute
+)
+
+// workerStatus tracks the informer sync and the activity of the worker of a controller
+type workerStatus struct {
+	mu sync.Mutex
+
+	synced          bool
+	processingKey   interface{}
+	processingSince time.Time
+	lastProcessed   time.Time
+}
+
+func (s *workerStatus) setSynced() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.synced = true
+	s.lastProcessed = time.Now()
+}
+
+func (s *workerStatus) startProcessing(key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.processingKey = key
+	s.processingSince = time.Now()
+}
+
+func (s *workerStatus) doneProcessing() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.processingKey = nil
+	s.lastProcessed = time.Now()
+}
+
+func (s *workerStatus) hasSynced() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.synced
+}
+
+// healthy returns an error if the worker is stuck on a key, or does not pick keys from the queue
+func (s *workerStatus) healthy(queue workqueue.Interface) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.synced {
+		// the worker is not started yet
+		return nil
+	}
+
+	if s.processingKey != nil {
+		if time.Since(s.processingSince) > workerStallTimeout {
+			return fmt.Errorf("worker stuck on key %v since %s", s.processingKey, s.processingSince.Format(time.RFC3339))
+		}
+		return nil
+	}
+
+	if queue.Len() != 0 && time.Since(s.lastProcessed) > workerStallTimeout {
+		return fmt.Errorf("worker did not process any of the %d queued keys since %s", queue.Len(), s.lastProcessed.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// HasSynced returns whether the node informer cache is synced
+func (c *NodeController) HasSynced() bool {
+	return c.status.hasSynced()
+}
+
+// Healthy returns an error if the node worker has stopped processing its queue
+func (c *NodeController) Healthy() error {
+	return c.status.healthy(c.queue)
+}
+
+// HasSynced returns whether the service informer cache is synced
+func (c *SvcController) HasSynced() bool {
+	return c.status.hasSynced()
+}
+
+// Healthy returns an error if the service worker has stopped processing its queue
+func (c *SvcController) Healthy() error {
+	return c.status.healthy(c.queue)
+}
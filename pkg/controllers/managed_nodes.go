@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"encoding/json"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	klog "k8s.io/klog/v2"
+)
+
+// managedNode is what is persisted about a node the controller created resources for.
+// It proves the ownership of the resources left behind by the node once it is gone.
+type managedNode struct {
+	IPs  []string `json:"ips,omitempty"`
+	Zone string   `json:"zone,omitempty"`
+}
+
+// UseStateConfigMap persists the managed nodes in the given ConfigMap, for the garbage collection
+// to find the resources of the nodes deleted while the controller was down. It must be called before Run.
+func (c *NodeController) UseStateConfigMap(namespace string, name string) {
+	c.state = newConfigMapStore(c.clientset, namespace, name)
+}
+
+// managedNodes returns the persisted managed nodes by name
+func (c *NodeController) managedNodes() (map[string]managedNode, error) {
+	nodes := make(map[string]managedNode)
+	if c.state == nil {
+		return nodes, nil
+	}
+
+	data, err := c.state.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range data {
+		var node managedNode
+		err := json.Unmarshal([]byte(value), &node)
+		if err != nil {
+			klog.Warningf("ignoring invalid managed node %s: %v", name, err)
+			continue
+		}
+		nodes[name] = node
+	}
+	return nodes, nil
+}
+
+// recordManagedNode persists the node along with its current addresses and zone
+func (c *NodeController) recordManagedNode(node *v1.Node) error {
+	if c.state == nil {
+		return nil
+	}
+
+	wanted := managedNode{
+		Zone: getNodeZone(node).String(),
+	}
+	externalIP, internalIP := getNodeAddresses(node)
+	for _, ip := range appendIPs(nil, externalIP, internalIP, getNodeIPv6(node)) {
+		wanted.IPs = append(wanted.IPs, ip.String())
+	}
+
+	nodes, err := c.managedNodes()
+	if err != nil {
+		return err
+	}
+	if current, ok := nodes[node.Name]; ok && reflect.DeepEqual(current, wanted) {
+		return nil
+	}
+
+	value, err := json.Marshal(wanted)
+	if err != nil {
+		return err
+	}
+
+	err = c.state.Update(func(data map[string]string) bool {
+		if data[node.Name] == string(value) {
+			return false
+		}
+		data[node.Name] = string(value)
+		return true
+	})
+	if err != nil {
+		klog.Errorf("could not record managed node %s: %v", node.Name, err)
+		return err
+	}
+	return nil
+}
+
+// forgetManagedNodes removes nodes whose resources have all been cleaned up
+func (c *NodeController) forgetManagedNodes(names ...string) error {
+	if c.state == nil {
+		return nil
+	}
+
+	nodes, err := c.managedNodes()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, name := range names {
+		if _, ok := nodes[name]; ok {
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	err = c.state.Update(func(data map[string]string) bool {
+		changed := false
+		for _, name := range names {
+			if _, ok := data[name]; ok {
+				delete(data, name)
+				changed = true
+			}
+		}
+		return changed
+	})
+	if err != nil {
+		klog.Errorf("could not forget managed nodes %v: %v", names, err)
+		return err
+	}
+	return nil
+}
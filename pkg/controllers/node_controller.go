@@ -1,18 +1,19 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"strconv"
-	"strings"
+	"reflect"
 	"time"
 
-	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
-	"github.com/scaleway/scaleway-sdk-go/scw"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
@@ -20,21 +21,20 @@ import (
 )
 
 const (
-	ReverseIPDomainEnv      = "REVERSE_IP_DOMAIN"
-	DatabaseIDsEnv          = "DATABASE_IDS"
-	RedisIDsEnv             = "REDIS_IDS"
-	ReservedIPsPoolEnv      = "RESERVED_IPS_POOL"
-	SecurityGroupIDs        = "SECURITY_GROUP_IDS"
-	NumberRetries           = "NUMBER_RETRIES"
-	NodesIPSource           = "NODES_IP_SOURCE"
-	NodesIPSourceKubernetes = "kubernetes"
-	NodeLabelReservedIP     = "reserved-ip"
+	NodeLabelReservedIP = "reserved-ip"
 )
 
-func NewNodeController(clientset *kubernetes.Clientset) (*NodeController, error) {
-	nodeListWatcher := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "nodes", "", fields.Everything())
+func NewNodeController(clientset kubernetes.Interface, scwClient *scaleway.Client, cfg *config.Config) (*NodeController, error) {
+	nodeListWatcher := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (k8sruntime.Object, error) {
+			return clientset.CoreV1().Nodes().List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return clientset.CoreV1().Nodes().Watch(context.Background(), options)
+		},
+	}
 
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "node")
 
 	indexer, informer := cache.NewIndexerInformer(nodeListWatcher, &v1.Node{}, 0, cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
@@ -54,6 +54,15 @@ func NewNodeController(clientset *kubernetes.Clientset) (*NodeController, error)
 						queue.Add(key)
 						return
 					}
+					if oldNode.DeletionTimestamp == nil && newNode.DeletionTimestamp != nil {
+						queue.Add(key)
+						return
+					}
+					// the labels select the features of the node
+					if !reflect.DeepEqual(oldNode.Labels, newNode.Labels) {
+						queue.Add(key)
+						return
+					}
 					for _, oldAddress := range oldNode.Status.Addresses {
 						for _, newAddress := range newNode.Status.Addresses {
 							if oldAddress.Type == newAddress.Type && oldAddress.Address != newAddress.Address {
@@ -73,117 +82,131 @@ func NewNodeController(clientset *kubernetes.Clientset) (*NodeController, error)
 		},
 	}, cache.Indexers{})
 
-	scwClient, err := scw.NewClient(scw.WithEnv())
-	if err != nil {
-		return nil, err
-	}
-
 	controller := &NodeController{
-		indexer:       indexer,
-		informer:      informer,
-		queue:         queue,
-		scwClient:     scwClient,
-		numberRetries: defaultNumberRetries,
-		clientset:     clientset,
-		scwZoneFound:  false,
-		scwZone:       "",
+		indexer:   indexer,
+		informer:  informer,
+		queue:     queue,
+		scwClient: scwClient,
+		recorder:  newEventRecorder(clientset),
+		clientset: clientset,
 	}
 
-	// TODO handle validation here ?
-	if os.Getenv(ReverseIPDomainEnv) != "" {
-		controller.reverseIPDomain = os.Getenv(ReverseIPDomainEnv)
+	controller.syncers = []NodeSyncer{
+		&nodeSyncerFuncs{
+			name:    FeatureReservedIP,
+			enabled: controller.hasReservedIPs,
+			sync:    controller.syncReservedIP,
+			cleanup: controller.cleanupReservedIP,
+		},
+		&nodeSyncerFuncs{
+			name:    FeatureReverseIP,
+			enabled: func() bool { return controller.getConfig().ReverseIPDomain != "" },
+			sync:    controller.syncReverseIP,
+			cleanup: controller.cleanupReverseIP,
+		},
+		&nodeSyncerFuncs{
+			name:    FeatureDatabaseACLs,
+			enabled: func() bool { return len(controller.getConfig().DatabaseIDs) != 0 },
+			sync:    controller.syncDatabaseACLs,
+			cleanup: controller.cleanupDatabaseACLs,
+		},
+		&nodeSyncerFuncs{
+			name:    FeatureRedisACLs,
+			enabled: func() bool { return len(controller.getConfig().RedisIDs) != 0 },
+			sync:    controller.syncRedisACLs,
+			cleanup: controller.cleanupRedisACLs,
+		},
+		&nodeSyncerFuncs{
+			name:    FeatureSecurityGroup,
+			enabled: func() bool { return len(controller.getConfig().SecurityGroupIDs) != 0 },
+			sync:    controller.syncSecurityGroup,
+			cleanup: controller.cleanupSecurityGroup,
+		},
+		&nodeSyncerFuncs{
+			name:    FeatureNodeDNS,
+			enabled: func() bool { return controller.getConfig().NodeDNS.Domain != "" },
+			sync:    controller.syncNodeDNS,
+			cleanup: controller.cleanupNodeDNS,
+		},
 	}
 
-	if os.Getenv(DatabaseIDsEnv) != "" {
-		controller.databaseIDs = strings.Split(os.Getenv(DatabaseIDsEnv), ",")
-	}
+	controller.setConfig(cfg)
 
-	if os.Getenv(RedisIDsEnv) != "" {
-		controller.redisIDs = strings.Split(os.Getenv(RedisIDsEnv), ",")
-	}
+	return controller, nil
+}
 
-	if os.Getenv(ReservedIPsPoolEnv) != "" {
-		controller.reservedIPs = strings.Split(os.Getenv(ReservedIPsPoolEnv), ",")
+func (c *NodeController) syncNeeded(nodeName string) error {
+	nodeObj, exists, err := c.indexer.GetByKey(nodeName)
+	if err != nil {
+		klog.Errorf("could not get node %s by key: %v", nodeName, err)
+		return err
 	}
 
-	if os.Getenv(SecurityGroupIDs) != "" {
-		controller.securityGroupIDs = strings.Split(os.Getenv(SecurityGroupIDs), ",")
+	node := deletedNode(nodeName)
+	if exists {
+		var ok bool
+		node, ok = nodeObj.(*v1.Node)
+		if !ok {
+			klog.Errorf("could not get node %s from object", nodeName)
+			return fmt.Errorf("could not get node %s from object", nodeName)
+		}
 	}
 
-	if os.Getenv(NumberRetries) != "" {
-		numberRetriesValue, err := strconv.Atoi(os.Getenv(NumberRetries))
-		controller.numberRetries = numberRetriesValue
-		if err != nil {
-			klog.Errorf("could not parse the desired number of retries %s: %v", os.Getenv(NumberRetries), err)
-			controller.numberRetries = defaultNumberRetries
+	if exists && node.DeletionTimestamp != nil {
+		if !hasFinalizer(node) {
+			// the cleanup will happen once the node is gone
+			return nil
 		}
-	}
-	if controller.reverseIPDomain != "" {
-		// try to find a parent zone
-		maxPage := uint32(100)
-		dnsAPI := dns.NewAPI(controller.scwClient)
-		listing, err := dnsAPI.ListDNSZones(&dns.ListDNSZonesRequest{PageSize: &maxPage})
+		klog.Infof("node %s is being deleted, cleaning up", nodeName)
+		syncErr := newSyncError(nodeName, c.runSyncers(node, false))
+		if syncErr != nil {
+			return syncErr
+		}
+		err = c.removeFinalizer(node)
 		if err != nil {
-			klog.Errorf("could not get list of scaleway zones : %v", err)
-		} else {
-			for i := range listing.DNSZones {
-				if strings.HasSuffix(controller.reverseIPDomain, listing.DNSZones[i].Domain) {
-					subDomain := strings.TrimRight(controller.scwZone, listing.DNSZones[i].Domain)
-					if subDomain == "" || subDomain[len(subDomain)-1] == '.' {
-						zone := fmt.Sprintf("%s.%s", listing.DNSZones[i].Subdomain, listing.DNSZones[i].Domain)
-						if strings.HasPrefix(zone, ".") {
-							zone = zone[1:]
-						}
-						controller.scwZoneFound = true
-						if len(controller.scwZone) < len(zone) {
-							controller.scwZone = zone
-						}
-					}
-				}
-			}
+			return err
 		}
-		if controller.scwZoneFound {
-			klog.Infof("found an scaleway zone %s", controller.scwZone)
+		// nothing was removed in dry-run mode, the garbage collection finds the resources once it is disabled
+		if c.scwClient.DryRun {
+			return nil
 		}
+		return c.forgetManagedNodes(nodeName)
 	}
 
-	return controller, nil
-}
-
-func (c *NodeController) syncNeeded(nodeName string) error {
-	var errs []error
-
-	err := c.syncReservedIP(nodeName)
-	if err != nil {
-		klog.Errorf("failed to sync reserved IP for node %s: %v", nodeName, err)
-		errs = append(errs, err)
-	}
-	err = c.syncReverseIP(nodeName)
-	if err != nil {
-		klog.Errorf("failed to sync reverse IP for node %s: %v", nodeName, err)
-		errs = append(errs, err)
+	if !exists {
+		// the resources left behind are collected by the garbage collection
+		return newSyncError(nodeName, c.runSyncers(node, false))
 	}
-	err = c.syncDatabaseACLs(nodeName)
+
+	err = c.ensureFinalizer(node)
 	if err != nil {
-		klog.Errorf("failed to sync database acl for node %s: %v", nodeName, err)
-		errs = append(errs, err)
+		return err
 	}
-	err = c.syncRedisACLs(nodeName)
-	if err != nil {
-		klog.Errorf("failed to sync redis acl for node %s: %v", nodeName, err)
-		errs = append(errs, err)
+
+	// recorded before creating anything, for the garbage collection to find the resources
+	// if the node is deleted while the controller is down. A failure only weakens the collection.
+	// Nothing is created in dry-run mode.
+	if !c.scwClient.DryRun {
+		_ = c.recordManagedNode(node)
 	}
-	err = c.syncSecurityGroup(nodeName)
-	if err != nil {
-		klog.Errorf("failed to sync security group for node %s: %v", nodeName, err)
-		errs = append(errs, err)
+
+	// a node without reserved IP feature has no IP to wait for
+	if !c.hasReservedIPs() || !c.selectsNode(FeatureReservedIP, node) {
+		err = c.setReservedIPPendingTaint(node, false)
+		if err != nil {
+			return err
+		}
 	}
 
-	if len(errs) == 0 {
-		return nil
+	syncErr := newSyncError(nodeName, c.runSyncers(node, true))
+
+	// a failure to record the outcome is retried along with the sync
+	err = c.setLastSync(node, syncErr)
+	if err != nil && syncErr == nil {
+		return err
 	}
 
-	return fmt.Errorf("got several error")
+	return syncErr
 }
 
 func (c *NodeController) processNextItem() bool {
@@ -193,6 +216,9 @@ func (c *NodeController) processNextItem() bool {
 	}
 	defer c.queue.Done(key)
 
+	c.status.startProcessing(key)
+	defer c.status.doneProcessing()
+
 	err := c.syncNeeded(key.(string))
 	c.handleErr(err, key)
 	return true
@@ -204,7 +230,7 @@ func (c *NodeController) handleErr(err error, key interface{}) {
 		return
 	}
 
-	if c.queue.NumRequeues(key) < c.numberRetries {
+	if c.queue.NumRequeues(key) < c.getConfig().NumberRetries {
 		c.queue.AddRateLimited(key)
 		return
 	}
@@ -220,14 +246,37 @@ func (c *NodeController) Run(stopCh chan struct{}) {
 
 	defer c.queue.ShutDown()
 
+	// the ConfigMaps may have been written by a previous leader
+	for _, store := range []*configMapStore{c.state, c.ipAssignments} {
+		if store != nil {
+			store.Reset()
+		}
+	}
+
 	go c.informer.Run(stopCh)
 
 	if !cache.WaitForCacheSync(stopCh, c.informer.HasSynced) {
 		runtime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
 		return
 	}
-
-	go wait.Until(c.runWorker, time.Second, stopCh)
+	c.status.setSynced()
+	c.warnUnknownSelectors()
+
+	// the goroutines are part of Wg, for a sync in progress to finish before the controller is considered stopped
+	c.Wg.Add(1)
+	go func() {
+		defer c.Wg.Done()
+		wait.Until(c.runWorker, time.Second, stopCh)
+	}()
+	c.Wg.Add(2)
+	go func() {
+		defer c.Wg.Done()
+		c.runGarbageCollector(stopCh)
+	}()
+	go func() {
+		defer c.Wg.Done()
+		c.runReservedIPReleaser(stopCh)
+	}()
 
 	<-stopCh
 }
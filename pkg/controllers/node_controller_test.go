@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway/fake"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// countingSyncer counts the syncs and cleanups of the nodes
+type countingSyncer struct {
+	mu       sync.Mutex
+	syncs    int
+	cleanups int
+}
+
+func (s *countingSyncer) Name() string {
+	return "counting"
+}
+
+func (s *countingSyncer) Enabled() bool {
+	return true
+}
+
+func (s *countingSyncer) SyncNode(node *v1.Node) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.syncs++
+	return nil
+}
+
+func (s *countingSyncer) CleanupNode(node *v1.Node) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cleanups++
+	return nil
+}
+
+func (s *countingSyncer) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.syncs, s.cleanups
+}
+
+// patchNode applies the merge patch to the node
+func patchNode(t *testing.T, clientset kubernetes.Interface, name string, patch string) {
+	t.Helper()
+
+	_, err := clientset.CoreV1().Nodes().Patch(context.Background(), name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		t.Fatalf("could not patch node %s: %v", name, err)
+	}
+}
+
+// consistently checks that the condition stays met for a while, failing the test with the description otherwise
+func consistently(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(10 * testPollInterval); time.Now().Before(deadline); time.Sleep(testPollInterval) {
+		if !condition() {
+			t.Fatalf("expected %s", description)
+		}
+	}
+}
+
+func TestNodeUpdateFilter(t *testing.T) {
+	b := fake.NewBackend()
+	node := newTestNode("node-1")
+	node.Labels = map[string]string{"pool": "default"}
+	node.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "51.158.1.1"}}
+
+	counting := &countingSyncer{}
+	c, clientset := newTestNodeController(t, b.Client(), newTestConfig(), node)
+	c.AddSyncer(counting)
+	runNodeController(t, c)
+
+	// the patches of the sync itself, like the finalizer and the last sync time, do not sync the node again
+	waitFor(t, "the node to be synced", func() bool {
+		return getNode(t, clientset, "node-1").Annotations[AnnotationLastSyncTime] != ""
+	})
+	syncs, _ := counting.counts()
+	consistently(t, "no other sync", func() bool {
+		current, _ := counting.counts()
+		return current == syncs
+	})
+
+	patchNode(t, clientset, "node-1", `{"metadata":{"annotations":{"example.com/unrelated":"true"}}}`)
+	consistently(t, "no sync on an unrelated change", func() bool {
+		current, _ := counting.counts()
+		return current == syncs
+	})
+
+	patchNode(t, clientset, "node-1", `{"metadata":{"labels":{"pool":"egress"}}}`)
+	waitFor(t, "a sync on a label change", func() bool {
+		current, _ := counting.counts()
+		return current > syncs
+	})
+
+	syncs, _ = counting.counts()
+	patchNode(t, clientset, "node-1", `{"status":{"addresses":[{"type":"ExternalIP","address":"51.158.1.2"}]}}`)
+	waitFor(t, "a sync on an address change", func() bool {
+		current, _ := counting.counts()
+		return current > syncs
+	})
+
+	deleteNode(t, clientset, "node-1")
+	waitFor(t, "a cleanup on deletion", func() bool {
+		_, cleanups := counting.counts()
+		return cleanups == 1
+	})
+}
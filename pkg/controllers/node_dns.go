@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
+	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
+	v1 "k8s.io/api/core/v1"
+	klog "k8s.io/klog/v2"
+)
+
+// nodeDNSCommentPrefix comments the node DNS records, which are told apart from the reverse records that way
+const nodeDNSCommentPrefix = "k8s node-dns "
+
+func (c *NodeController) syncNodeDNS(node *v1.Node) error {
+	nodeName := node.Name
+	cfg := c.getConfig()
+
+	zone, privateZone := c.getNodeDNSZones()
+	if zone == "" {
+		klog.Warningf("skipping dns records of node %s, no scaleway zone holds %s", nodeName, cfg.NodeDNS.Domain)
+		return nil
+	}
+
+	publicIPs, privateIP, err := c.getNodeDNSAddresses(node)
+	if err != nil {
+		klog.Errorf("could not get the addresses of node %s: %v", nodeName, err)
+		return err
+	}
+
+	wanted := []nodeDNSRecord{}
+	for _, ip := range publicIPs {
+		wanted = append(wanted, nodeDNSRecord{
+			zone: zone,
+			name: fmt.Sprintf("%s.%s", nodeName, cfg.NodeDNS.Domain),
+			ip:   ip,
+		})
+	}
+	if cfg.NodeDNS.PrivateDomain != "" {
+		switch {
+		case privateZone == "":
+			klog.Warningf("skipping private dns record of node %s, no scaleway zone holds %s", nodeName, cfg.NodeDNS.PrivateDomain)
+		case privateIP == nil:
+			klog.Warningf("skipping private dns record of node %s without private IP", nodeName)
+		default:
+			wanted = append(wanted, nodeDNSRecord{
+				zone: privateZone,
+				name: fmt.Sprintf("%s.%s", nodeName, cfg.NodeDNS.PrivateDomain),
+				ip:   privateIP,
+			})
+		}
+	}
+
+	return c.reconcileNodeDNS(nodeName, wanted)
+}
+
+func (c *NodeController) cleanupNodeDNS(node *v1.Node) error {
+	return c.reconcileNodeDNS(node.Name, nil)
+}
+
+// getNodeDNSAddresses returns the public IPs and the private IP of the node, taken from NodesIPSource
+func (c *NodeController) getNodeDNSAddresses(node *v1.Node) ([]net.IP, net.IP, error) {
+	if c.getConfig().NodesIPSource == config.NodesIPSourceKubernetes {
+		publicIP, privateIP := getNodeAddresses(node)
+		return appendIPs(nil, publicIP, getNodeIPv6(node)), privateIP, nil
+	}
+
+	server, err := c.getInstanceFromNode(node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var publicIPs []net.IP
+	var privateIP net.IP
+	if server.PublicIP != nil {
+		publicIPs = appendIPs(publicIPs, server.PublicIP.Address)
+	}
+	if server.IPv6 != nil {
+		publicIPs = appendIPs(publicIPs, server.IPv6.Address)
+	}
+	if server.PrivateIP != nil && *server.PrivateIP != "" {
+		privateIP = net.ParseIP(*server.PrivateIP)
+	}
+	return publicIPs, privateIP, nil
+}
+
+// reconcileNodeDNS adds the wanted records of the node and removes its other records, in every node DNS zone
+func (c *NodeController) reconcileNodeDNS(nodeName string, wanted []nodeDNSRecord) error {
+	zone, privateZone := c.getNodeDNSZones()
+	zones := []string{}
+	for _, z := range []string{zone, privateZone} {
+		if z != "" && !stringInSlice(z, zones) {
+			zones = append(zones, z)
+		}
+	}
+
+	gotErr := false
+	for _, z := range zones {
+		_, err := c.updateNodeRecords(nodeName, z, nodeDNSCommentPrefix, wanted, EventReasonNodeDNSFailed)
+		if err != nil {
+			gotErr = true
+		}
+	}
+	if gotErr {
+		return fmt.Errorf("could not update the dns records of node %s", nodeName)
+	}
+	return nil
+}
+
+// listNodeDNSRecords returns the node DNS records owned by the cluster in the zone
+func (c *NodeController) listNodeDNSRecords(zone string) ([]*dns.Record, error) {
+	return c.listOwnedRecords(zone, nodeDNSCommentPrefix)
+}
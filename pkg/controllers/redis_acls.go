@@ -3,81 +3,36 @@ package controllers
 import (
 	"fmt"
 	"net"
-	"os"
 
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
 	redis "github.com/scaleway/scaleway-sdk-go/api/redis/v1"
 	"github.com/scaleway/scaleway-sdk-go/scw"
 	"k8s.io/api/core/v1"
 	klog "k8s.io/klog/v2"
 )
 
-func (c *NodeController) syncRedisACLs(nodeName string) error {
-	if len(c.redisIDs) == 0 {
-		return nil
-	}
-
-	var node *v1.Node
+func (c *NodeController) syncRedisACLs(node *v1.Node) error {
+	nodeName := node.Name
 
 	retryOnError := false
 
-	nodeObj, exists, err := c.indexer.GetByKey(nodeName)
-	if err != nil {
-		klog.Errorf("could not get node %s by key: %v", nodeName, err)
-		return err
-	}
-	if exists {
-		var ok bool
-		node, ok = nodeObj.(*v1.Node)
-		if !ok {
-			klog.Errorf("could not get node %s from object", nodeName)
-			return fmt.Errorf("could not get node %s from object", nodeName)
-		}
-	}
+	dbAPI := c.scwClient.Redis
 
-	dbAPI := redis.NewAPI(c.scwClient)
+	allowed := []string{}
 
-	for _, redisID := range c.redisIDs {
+	for _, redisID := range c.getConfig().RedisIDs {
 		klog.Infof("whitelisting IP on node %s on redis instance %s", nodeName, redisID)
 
-		id, zone, err := getRegionalizedID(redisID)
+		dbInstance, rule, err := c.getRedisACLRule(redisID, nodeName)
 		if err != nil {
-			klog.Errorf("could not get id and zone from %s: %v", redisID, err)
-			continue
-		}
-
-		dbInstance, err := dbAPI.GetCluster(&redis.GetClusterRequest{
-			Zone:      scw.Zone(zone),
-			ClusterID: id,
-		})
-		if err != nil {
-			klog.Errorf("could not get redis instance %s: %v", id, err)
-			continue
-		}
-
-		var rule *redis.ACLRule
-
-		for _, acl := range dbInstance.ACLRules {
-			if *acl.Description == nodeName {
-				rule = acl
-				break
-			}
-		}
-
-		if !exists && rule != nil {
-			_, err := dbAPI.DeleteACLRule(&redis.DeleteACLRuleRequest{
-				Zone:  dbInstance.Zone,
-				ACLID: rule.ID,
-			})
-			if err != nil {
-				klog.Errorf("could not delete acl rule for node %s on redis instance %s: %v", nodeName, dbInstance.ID, err)
-				retryOnError = true
-			}
+			id, _, _ := getRegionalizedID(redisID)
+			allowed = append(allowed, recordedIDs(node, AnnotationRedisClusters, id)...)
 			continue
 		}
 
 		var nodePublicIP net.IP
 
-		if os.Getenv(NodesIPSource) == NodesIPSourceKubernetes {
+		if c.getConfig().NodesIPSource == config.NodesIPSourceKubernetes {
 			for _, addr := range node.Status.Addresses {
 				if addr.Type == v1.NodeExternalIP {
 					// prefer ipv4 over ipv6 since Redis instances are only accessible via ipv4
@@ -89,9 +44,10 @@ func (c *NodeController) syncRedisACLs(nodeName string) error {
 				}
 			}
 		} else {
-			server, err := c.getInstanceFromNodeName(nodeName)
+			server, err := c.getInstanceFromNode(node)
 			if err != nil {
 				klog.Errorf("could not get instance %s: %v", nodeName, err)
+				allowed = append(allowed, recordedIDs(node, AnnotationRedisClusters, dbInstance.ID)...)
 				continue
 			}
 
@@ -100,18 +56,16 @@ func (c *NodeController) syncRedisACLs(nodeName string) error {
 				continue
 			}
 
-			nodePublicIP = server.PublicIP.Address
+			// the ACLs of the redis clusters only accept IPv4
+			nodePublicIP = server.PublicIP.Address.To4()
 		}
 
 		if nodePublicIP == nil {
-			klog.Warningf("skipping node %s without public IP", nodeName)
+			klog.Warningf("skipping node %s without public IPv4", nodeName)
 			continue
 		}
 
-		nodeIP := net.IPNet{
-			IP:   nodePublicIP,
-			Mask: net.IPv4Mask(255, 255, 255, 255), // TODO better idea?
-		}
+		nodeIP := hostIPNet(nodePublicIP)
 
 		if rule == nil || nodeIP.String() != rule.IPCidr.String() {
 			_, err := dbAPI.AddACLRules(&redis.AddACLRulesRequest{
@@ -126,9 +80,23 @@ func (c *NodeController) syncRedisACLs(nodeName string) error {
 			})
 			if err != nil {
 				klog.Errorf("could not add acl rule for node %s with ip %s on redis instance %s: %v", nodeName, nodeIP.String(), dbInstance.ID, err)
+				c.eventf(nodeName, v1.EventTypeWarning, EventReasonRedisACLFailed, "Could not add ACL rule %s on redis cluster %s/%s: %v", nodeIP.String(), dbInstance.Zone, dbInstance.ID, err)
+				allowed = append(allowed, recordedIDs(node, AnnotationRedisClusters, dbInstance.ID)...)
 				retryOnError = true
 				continue
 			}
+			c.eventf(nodeName, v1.EventTypeNormal, EventReasonRedisACLAdded, "Added ACL rule %s on redis cluster %s/%s", nodeIP.String(), dbInstance.Zone, dbInstance.ID)
+		}
+		allowed = append(allowed, fmt.Sprintf("%s/%s", dbInstance.Zone, dbInstance.ID))
+	}
+
+	// the rules are not added in dry-run mode, so the node is left as is
+	if !c.scwClient.DryRun {
+		err := c.setNodeAnnotations(node, map[string]string{
+			AnnotationRedisClusters: joinAnnotation(allowed),
+		})
+		if err != nil {
+			return err
 		}
 	}
 
@@ -138,3 +106,73 @@ func (c *NodeController) syncRedisACLs(nodeName string) error {
 
 	return nil
 }
+
+func (c *NodeController) cleanupRedisACLs(node *v1.Node) error {
+	nodeName := node.Name
+
+	retryOnError := false
+
+	dbAPI := c.scwClient.Redis
+
+	for _, redisID := range withRecordedIDs(c.getConfig().RedisIDs, node, AnnotationRedisClusters) {
+		dbInstance, rule, err := c.getRedisACLRule(redisID, nodeName)
+		if err != nil {
+			// a deleted cluster has no rule left
+			if isNotFound(err) {
+				continue
+			}
+			c.eventf(nodeName, v1.EventTypeWarning, EventReasonRedisACLFailed, "Could not get ACL rules of redis cluster %s: %v", redisID, err)
+			retryOnError = true
+			continue
+		}
+		if rule == nil {
+			continue
+		}
+
+		_, err = dbAPI.DeleteACLRule(&redis.DeleteACLRuleRequest{
+			Zone:  dbInstance.Zone,
+			ACLID: rule.ID,
+		})
+		if err != nil {
+			klog.Errorf("could not delete acl rule for node %s on redis instance %s: %v", nodeName, dbInstance.ID, err)
+			c.eventf(nodeName, v1.EventTypeWarning, EventReasonRedisACLFailed, "Could not remove ACL rule %s on redis cluster %s/%s: %v", rule.IPCidr.String(), dbInstance.Zone, dbInstance.ID, err)
+			retryOnError = true
+			continue
+		}
+		c.eventf(nodeName, v1.EventTypeNormal, EventReasonRedisACLRemoved, "Removed ACL rule %s on redis cluster %s/%s", rule.IPCidr.String(), dbInstance.Zone, dbInstance.ID)
+	}
+
+	if retryOnError {
+		return fmt.Errorf("got retryable error")
+	}
+
+	return nil
+}
+
+// getRedisACLRule returns the redis cluster and the ACL rule named after the node, if any
+func (c *NodeController) getRedisACLRule(redisID string, nodeName string) (*redis.Cluster, *redis.ACLRule, error) {
+	dbAPI := c.scwClient.Redis
+
+	id, zone, err := getRegionalizedID(redisID)
+	if err != nil {
+		klog.Errorf("could not get id and zone from %s: %v", redisID, err)
+		return nil, nil, err
+	}
+
+	dbInstance, err := dbAPI.GetCluster(&redis.GetClusterRequest{
+		Zone:      scw.Zone(zone),
+		ClusterID: id,
+	})
+	if err != nil {
+		klog.Errorf("could not get redis instance %s: %v", id, err)
+		return nil, nil, err
+	}
+
+	for _, acl := range dbInstance.ACLRules {
+		if acl.Description != nil && *acl.Description == nodeName {
+			return dbInstance, acl, nil
+		}
+	}
+
+	return dbInstance, nil, nil
+}
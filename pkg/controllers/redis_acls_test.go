@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway/fake"
+)
+
+func TestRedisACLs(t *testing.T) {
+	b := fake.NewBackend()
+	server := b.AddServer("node-1", "", "51.158.1.1", "10.0.0.1")
+	b.SetServerIPv6(server.ID, "2001:db8::1")
+	cluster := b.AddRedisCluster("22222222-2222-4222-8222-222222222222", "")
+
+	cfg := newTestConfig()
+	cfg.RedisIDs = []string{cluster.ID}
+	clientset := startNodeController(t, b.Client(), cfg, newTestNode("node-1"))
+
+	// only the IPv4 of the node is allowed
+	waitFor(t, "the ACL rule to be added", func() bool {
+		rules := b.RedisACLRules(cluster.ID)
+		return len(rules) == 1 && rules[0].IPCidr.String() == "51.158.1.1/32"
+	})
+	waitFor(t, "the node to be annotated", func() bool {
+		return getNode(t, clientset, "node-1").Annotations[AnnotationRedisClusters] != ""
+	})
+
+	deleteNode(t, clientset, "node-1")
+	waitFor(t, "the ACL rule to be removed", func() bool {
+		return len(b.RedisACLRules(cluster.ID)) == 0
+	})
+}
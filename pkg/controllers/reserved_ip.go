@@ -1,114 +1,433 @@
 package controllers
 
 import (
-	"context"
+	"errors"
 	"fmt"
+	"net"
+	"strings"
 
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/metrics"
 	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
 	"k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	klog "k8s.io/klog/v2"
 )
 
-func (c *NodeController) syncReservedIP(nodeName string) error {
-	if len(c.reservedIPs) == 0 {
-		return nil
-	}
+// reservedIPAttachAttempts is how many IPs are tried for a node when they get taken by other servers
+const reservedIPAttachAttempts = 3
 
-	klog.Infof("adding a reserved IP on node %s", nodeName)
+var errReservedIPTaken = errors.New("reserved IP taken by another server")
 
-	_, exists, err := c.indexer.GetByKey(nodeName)
-	if err != nil {
-		klog.Errorf("could not get node %s by key: %v", nodeName, err)
-		return err
-	}
+func (c *NodeController) syncReservedIP(node *v1.Node) error {
+	nodeName := node.Name
 
-	if !exists {
-		// ip will be detached on delete
-		klog.Infof("node %s was deleted, ignoring", nodeName)
-		return nil
-	}
+	klog.Infof("adding a reserved IP on node %s", nodeName)
 
-	server, err := c.getInstanceFromNodeName(nodeName)
+	server, err := c.getInstanceFromNode(node)
 	if err != nil {
 		klog.Errorf("could not get server %s: %v", nodeName, err)
 		return err
 	}
 
 	if server.PublicIP == nil {
-		klog.Warning("node %s does not have a public IP")
+		klog.Warningf("node %s does not have a public IP", nodeName)
 		return nil
 	}
 
 	if !server.PublicIP.Dynamic {
 		klog.Warningf("node %s already have a public IP", nodeName)
-		err = c.addReservedIPLabel(nodeName)
+		err = c.setReservedIP(node, server.Zone, server.PublicIP.ID)
 		if err != nil {
 			return err
 		}
 
-		return nil
+		err = c.recordIPAssignment(node, server.Zone, server.PublicIP.ID, server.PublicIP.Address.String())
+		if err != nil {
+			return err
+		}
+
+		return c.setReservedIPPendingTaint(node, false)
 	}
 
-	ip, err := c.getFreeIP()
+	pool := c.getReservedIPPool(node)
+	if pool == nil {
+		klog.Infof("no reserved IP pool selects node %s", nodeName)
+		return c.setReservedIPPendingTaint(node, false)
+	}
+
+	// the taint would never be removed in dry-run mode, as nothing gets attached
+	if c.getConfig().ReservedIPPendingTaint && !c.scwClient.DryRun {
+		err = c.setReservedIPPendingTaint(node, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the IPs are picked and attached one node at a time, so that two nodes never get the same free IP
+	c.reservedIPMu.Lock()
+	defer c.reservedIPMu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		ip, err := c.pickReservedIP(node, server, pool)
+		if err != nil || ip == nil {
+			return err
+		}
+
+		err = c.attachReservedIP(node, server, pool, ip)
+		if err != errReservedIPTaken {
+			return err
+		}
+		if attempt == reservedIPAttachAttempts {
+			c.eventf(nodeName, v1.EventTypeWarning, EventReasonReservedIPFailed, "Could not attach a reserved IP of pool %s after %d attempts, the IPs being taken by other servers", pool.name, attempt)
+			return err
+		}
+		klog.Warningf("reserved IP %s was taken by another server, trying another one for node %s", ip.Address.String(), nodeName)
+	}
+}
+
+// pickReservedIP returns the IP of the pool to attach to the node: the one assigned to its sticky key if free,
+// a free one otherwise, or a newly booked one. It returns nil when the pool is exhausted.
+func (c *NodeController) pickReservedIP(node *v1.Node, server *instance.Server, pool *reservedIPPool) (*instance.IP, error) {
+	nodeName := node.Name
+
+	assignments, err := c.getIPAssignments()
+	if err != nil {
+		klog.Errorf("could not get reserved IP assignments: %v", err)
+		return nil, err
+	}
+	key := c.stickyKey(node)
+
+	ip, err := c.getAssignedIP(assignments, key, server.Zone, pool)
+	if err != nil {
+		klog.Errorf("could not get the assigned reserved IP of node %s: %v", nodeName, err)
+		return nil, err
+	}
+	if ip != nil {
+		klog.Infof("giving back reserved IP %s of %s to node %s", ip.Address.String(), key, nodeName)
+		return ip, nil
+	}
+
+	ip, usage, err := c.getFreeIP(server.Zone, pool, assignedIPIDs(assignments, key))
 	if err != nil {
 		klog.Errorf("could not get a free IP for node %s: %v", nodeName, err)
-		return err
+		return nil, err
+	}
+
+	if ip == nil && pool.autoProvision != nil && usage.size() < pool.autoProvision.MaxSize {
+		ip, err = c.provisionIP(server.Zone, pool)
+		if err != nil {
+			klog.Errorf("could not book a reserved IP of pool %s for node %s: %v", pool.name, nodeName, err)
+			c.eventf(nodeName, v1.EventTypeWarning, EventReasonReservedIPFailed, "Could not book a new reserved IP of pool %s in zone %s: %v", pool.name, server.Zone, err)
+			return nil, err
+		}
+		c.eventf(nodeName, v1.EventTypeNormal, EventReasonReservedIPProvisioned, "Booked reserved IP %s for pool %s", ip.Address.String(), pool.name)
 	}
 
 	if ip == nil {
-		klog.Warningf("no available reserved IPs for node %s", nodeName)
-		return nil
+		klog.Warningf("reserved IP pool %s is exhausted in zone %s for node %s", pool.name, server.Zone, nodeName)
+		c.eventf(nodeName, v1.EventTypeWarning, EventReasonReservedIPPoolExhausted, "No free reserved IP left in pool %s for zone %s", pool.name, server.Zone)
+		if len(usage.otherZones) != 0 {
+			c.eventf(nodeName, v1.EventTypeWarning, EventReasonZoneMismatch, "Reserved IPs %s can't be attached as they are not in zone %s", strings.Join(usage.otherZones, ", "), server.Zone)
+		}
 	}
+	return ip, nil
+}
 
-	instanceAPI := instance.NewAPI(c.scwClient)
+// attachReservedIP attaches the IP of the pool to the server of the node, and records it on the node.
+// The IP is checked to be free right before, and attached to the server right after, errReservedIPTaken
+// being returned if another server has it.
+func (c *NodeController) attachReservedIP(node *v1.Node, server *instance.Server, pool *reservedIPPool, ip *instance.IP) error {
+	nodeName := node.Name
+	instanceAPI := c.scwClient.Instance
+
+	current, err := c.getIPServer(server.Zone, ip)
+	if err != nil {
+		klog.Errorf("could not get IP %s for node %s: %v", ip.ID, nodeName, err)
+		return err
+	}
+	if current != nil {
+		return errReservedIPTaken
+	}
 
 	_, err = instanceAPI.UpdateIP(&instance.UpdateIPRequest{
-		IP: ip.ID,
+		Zone: server.Zone,
+		IP:   ip.ID,
 		Server: &instance.NullableStringValue{
 			Value: server.ID,
 		},
 	})
 	if err != nil {
 		klog.Errorf("could not attach IP %s for node %s: %v", ip.ID, nodeName, err)
+		c.eventf(nodeName, v1.EventTypeWarning, EventReasonReservedIPFailed, "Could not attach reserved IP %s: %v", ip.Address.String(), err)
 		return err
 	}
 
-	err = c.addReservedIPLabel(nodeName)
+	// nothing is attached in dry-run mode, so the node is left as is
+	if c.scwClient.DryRun {
+		klog.Infof("dry-run: would attach reserved IP %s of pool %s to node %s", ip.Address.String(), pool.name, nodeName)
+		return nil
+	}
+
+	current, err = c.getIPServer(server.Zone, ip)
 	if err != nil {
+		klog.Errorf("could not check IP %s of node %s: %v", ip.ID, nodeName, err)
 		return err
 	}
+	if current == nil || current.ID != server.ID {
+		return errReservedIPTaken
+	}
+	c.eventf(nodeName, v1.EventTypeNormal, EventReasonReservedIPAttached, "Attached reserved IP %s of pool %s", ip.Address.String(), pool.name)
+	c.refreshReservedIPsMetric(server.Zone)
 
-	return nil
-}
+	err = c.setReservedIP(node, server.Zone, ip.ID)
+	if err != nil {
+		return err
+	}
 
-func (c *NodeController) addReservedIPLabel(nodeName string) error {
-	nodeObj, _, err := c.indexer.GetByKey(nodeName)
+	err = c.recordIPAssignment(node, server.Zone, ip.ID, ip.Address.String())
 	if err != nil {
-		klog.Errorf("could not get node %s by key: %v", nodeName, err)
 		return err
 	}
 
-	node, ok := nodeObj.(*v1.Node)
-	if !ok {
-		klog.Errorf("could not get node %s from obejct", nodeName)
-		return fmt.Errorf("could not get node %s from obejct", nodeName)
+	return c.setReservedIPPendingTaint(node, false)
+}
+
+// getIPServer returns the server the IP is currently attached to, nil if it is free
+func (c *NodeController) getIPServer(zone scw.Zone, ip *instance.IP) (*instance.ServerSummary, error) {
+	// the IPs booked in dry-run mode do not exist
+	if ip.ID == "" {
+		return nil, nil
 	}
 
-	if node.Labels == nil {
-		node.Labels = make(map[string]string)
+	resp, err := c.scwClient.Instance.GetIP(&instance.GetIPRequest{
+		Zone: zone,
+		IP:   ip.ID,
+	})
+	if err != nil {
+		return nil, err
 	}
+	return resp.IP.Server, nil
+}
 
-	if value, ok := node.Labels[NodeLabelReservedIP]; ok && value == "true" {
+// setReservedIP patches the reserved IP label and annotation (zone/id) on the node
+func (c *NodeController) setReservedIP(node *v1.Node, zone scw.Zone, ipID string) error {
+	labelValue := "true"
+	zonalID := fmt.Sprintf("%s/%s", zone, ipID)
+	err := c.patchNodeMetadata(node, map[string]*string{
+		NodeLabelReservedIP: &labelValue,
+	}, map[string]*string{
+		AnnotationReservedIPID: &zonalID,
+	})
+	if err != nil {
+		klog.Errorf("could not add reserved IP label to node %s: %v", node.Name, err)
+		return err
+	}
+	return nil
+}
+
+// cleanupReservedIP detaches the reserved IP recorded on the node, if it comes from the pool and is still
+// attached to the server of the node. The IP of a deleted server is detached by Scaleway.
+func (c *NodeController) cleanupReservedIP(node *v1.Node) error {
+	nodeName := node.Name
+
+	if node.Annotations[AnnotationReservedIPID] == "" {
+		return nil
+	}
+	ipID, zone, err := getZonalID(node.Annotations[AnnotationReservedIPID])
+	if err != nil {
+		klog.Warningf("ignoring invalid reserved IP annotation of node %s: %v", nodeName, err)
 		return nil
 	}
 
-	node.Labels[NodeLabelReservedIP] = "true"
+	instanceAPI := c.scwClient.Instance
+
+	resp, err := instanceAPI.GetIP(&instance.GetIPRequest{
+		Zone: scw.Zone(zone),
+		IP:   ipID,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		klog.Errorf("could not get IP %s of node %s: %v", ipID, nodeName, err)
+		return err
+	}
+	ip := resp.IP
+
+	if !c.inReservedPool(ip) || ip.Server == nil {
+		return nil
+	}
+	if _, serverID, err := parseProviderID(node.Spec.ProviderID); err == nil && ip.Server.ID != serverID {
+		return nil
+	}
+	if node.Spec.ProviderID == "" && ip.Server.Name != nodeName {
+		return nil
+	}
 
-	_, err = c.clientset.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{})
+	klog.Infof("detaching reserved IP %s from node %s", ip.Address.String(), nodeName)
+	_, err = instanceAPI.UpdateIP(&instance.UpdateIPRequest{
+		Zone:   ip.Zone,
+		IP:     ip.ID,
+		Server: &instance.NullableStringValue{Null: true},
+	})
 	if err != nil {
-		klog.Errorf("could not add reserved IP label to node %s: %v", nodeName, err)
+		klog.Errorf("could not detach IP %s from node %s: %v", ip.ID, nodeName, err)
+		c.eventf(nodeName, v1.EventTypeWarning, EventReasonReservedIPFailed, "Could not detach reserved IP %s: %v", ip.Address.String(), err)
 		return err
 	}
+	c.eventf(nodeName, v1.EventTypeNormal, EventReasonReservedIPDetached, "Detached reserved IP %s", ip.Address.String())
+	c.refreshReservedIPsMetric(ip.Zone)
+
+	return nil
+}
+
+// refreshReservedIPsMetric records the number of free and attached IPs of every reserved IP pool in the zone
+func (c *NodeController) refreshReservedIPsMetric(zone scw.Zone) {
+	c.configMu.RLock()
+	pools := c.ipPools
+	c.configMu.RUnlock()
+
+	if len(pools) == 0 || zone == "" {
+		return
+	}
+
+	ipsList, err := c.scwClient.Instance.ListIPs(&instance.ListIPsRequest{
+		Zone: zone,
+	}, scw.WithAllPages())
+	if err != nil {
+		klog.Warningf("could not list the IPs of zone %s for the reserved IPs metric: %v", zone, err)
+		return
+	}
+
+	for i := range pools {
+		free, attached := 0, 0
+		for _, ip := range ipsList.IPs {
+			if !pools[i].contains(ip) {
+				continue
+			}
+			if ip.Server != nil {
+				attached++
+			} else {
+				free++
+			}
+		}
+		metrics.SetReservedIPs(pools[i].name, zone.String(), free, attached)
+	}
+}
+
+// refreshReservedIPsMetrics records the usage of the reserved IP pools in the zones of the nodes and of the pools
+func (c *NodeController) refreshReservedIPsMetrics() {
+	zones := make(map[scw.Zone]bool)
+	for _, obj := range c.indexer.List() {
+		if node, ok := obj.(*v1.Node); ok {
+			zones[getNodeZone(node)] = true
+		}
+	}
+
+	c.configMu.RLock()
+	for _, pool := range c.ipPools {
+		for _, reservedIP := range pool.ips {
+			if _, zone, _ := getZonalID(reservedIP); zone != "" {
+				zones[scw.Zone(zone)] = true
+			}
+		}
+	}
+	c.configMu.RUnlock()
+
+	for zone := range zones {
+		c.refreshReservedIPsMetric(zone)
+	}
+}
+
+// reservedIPPool is a pool of reserved IPs along with the selector of its nodes
+type reservedIPPool struct {
+	name          string
+	selector      labels.Selector
+	ips           []string
+	tags          []string
+	autoProvision *config.AutoProvision
+	// ownerTags are the tags of the IPs booked for the pool, if the cluster has an ID
+	ownerTags []string
+}
+
+// contains returns whether the IP is one of the addresses of the pool, has all its tags, or was booked for it
+func (p *reservedIPPool) contains(ip *instance.IP) bool {
+	for _, reservedIP := range p.ips {
+		if address, _, _ := getZonalID(reservedIP); address == ip.Address.String() {
+			return true
+		}
+	}
+	return hasTags(ip.Tags, p.tags) || hasTags(ip.Tags, p.ownerTags)
+}
+
+// hasTags returns whether tags holds every wanted tag, and false if there is none
+func hasTags(tags []string, wanted []string) bool {
+	if len(wanted) == 0 {
+		return false
+	}
+	for _, tag := range wanted {
+		if !stringInSlice(tag, tags) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasReservedIPs returns whether a reserved IP pool is configured
+func (c *NodeController) hasReservedIPs() bool {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	return len(c.ipPools) != 0
+}
+
+// getReservedIPPool returns the first pool selecting the node, or nil if there is none
+func (c *NodeController) getReservedIPPool(node *v1.Node) *reservedIPPool {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	for i := range c.ipPools {
+		if c.ipPools[i].selector.Matches(labels.Set(node.Labels)) {
+			return &c.ipPools[i]
+		}
+	}
 	return nil
 }
+
+// getReservedIPIndex returns the pool listing the IP along with its position in the pool starting at 1,
+// or the pool of the node and 0 if no pool lists it
+func (c *NodeController) getReservedIPIndex(node *v1.Node, ip net.IP) (string, int) {
+	poolName, index := "", 0
+	c.configMu.RLock()
+	for _, pool := range c.ipPools {
+		for i, reservedIP := range pool.ips {
+			if address, _, _ := getZonalID(reservedIP); index == 0 && net.ParseIP(address).Equal(ip) {
+				poolName, index = pool.name, i+1
+			}
+		}
+	}
+	c.configMu.RUnlock()
+	if index != 0 {
+		return poolName, index
+	}
+
+	if pool := c.getReservedIPPool(node); pool != nil {
+		return pool.name, 0
+	}
+	return "", 0
+}
+
+// inReservedPool returns whether the IP is in one of the reserved IP pools
+func (c *NodeController) inReservedPool(ip *instance.IP) bool {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	for i := range c.ipPools {
+		if c.ipPools[i].contains(ip) {
+			return true
+		}
+	}
+	return false
+}
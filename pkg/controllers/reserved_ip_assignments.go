@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"encoding/json"
+
+	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	v1 "k8s.io/api/core/v1"
+	klog "k8s.io/klog/v2"
+)
+
+// ipAssignment is the reserved IP last attached to a node with a given sticky key
+type ipAssignment struct {
+	Zone    string `json:"zone"`
+	ID      string `json:"id"`
+	Address string `json:"address,omitempty"`
+	Node    string `json:"node,omitempty"`
+}
+
+// UseReservedIPAssignmentsConfigMap persists the reserved IP of each node in the given ConfigMap, for a
+// replacement node to get the IP of its predecessor back. It must be called before Run.
+func (c *NodeController) UseReservedIPAssignmentsConfigMap(namespace string, name string) {
+	c.ipAssignments = newConfigMapStore(c.clientset, namespace, name)
+}
+
+// stickyKey returns the key of the node in the reserved IP assignments, the value of the sticky label or the node name
+func (c *NodeController) stickyKey(node *v1.Node) string {
+	if label := c.getConfig().ReservedIPStickyLabel; label != "" && node.Labels[label] != "" {
+		return node.Labels[label]
+	}
+	return node.Name
+}
+
+// getIPAssignments returns the persisted reserved IP assignments by sticky key
+func (c *NodeController) getIPAssignments() (map[string]ipAssignment, error) {
+	assignments := make(map[string]ipAssignment)
+	if c.ipAssignments == nil {
+		return assignments, nil
+	}
+
+	data, err := c.ipAssignments.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range data {
+		var assignment ipAssignment
+		err := json.Unmarshal([]byte(value), &assignment)
+		if err != nil {
+			klog.Warningf("ignoring invalid reserved IP assignment %s: %v", key, err)
+			continue
+		}
+		assignments[key] = assignment
+	}
+	return assignments, nil
+}
+
+// getAssignedIP returns the IP assigned to the sticky key if it is still free, in the given zone and in the pool
+func (c *NodeController) getAssignedIP(assignments map[string]ipAssignment, key string, zone scw.Zone, pool *reservedIPPool) (*instance.IP, error) {
+	assignment, ok := assignments[key]
+	if !ok || assignment.Zone != zone.String() {
+		return nil, nil
+	}
+
+	resp, err := c.scwClient.Instance.GetIP(&instance.GetIPRequest{
+		Zone: zone,
+		IP:   assignment.ID,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ip := resp.IP
+
+	if !pool.contains(ip) {
+		klog.Infof("reserved IP %s of %s is not in pool %s anymore", ip.Address.String(), key, pool.name)
+		return nil, nil
+	}
+	if ip.Server != nil {
+		klog.Infof("reserved IP %s of %s is attached to server %s, using another one", ip.Address.String(), key, ip.Server.Name)
+		return nil, nil
+	}
+	return ip, nil
+}
+
+// assignedIPIDs returns the IDs of the IPs assigned to the other sticky keys
+func assignedIPIDs(assignments map[string]ipAssignment, key string) map[string]bool {
+	ids := make(map[string]bool)
+	for k, assignment := range assignments {
+		if k != key {
+			ids[assignment.ID] = true
+		}
+	}
+	return ids
+}
+
+// recordIPAssignment persists the IP as the one of the sticky key of the node, an IP being assigned to a single key
+func (c *NodeController) recordIPAssignment(node *v1.Node, zone scw.Zone, ipID string, address string) error {
+	if c.ipAssignments == nil || ipID == "" {
+		return nil
+	}
+
+	key := c.stickyKey(node)
+	wanted := ipAssignment{
+		Zone:    zone.String(),
+		ID:      ipID,
+		Address: address,
+		Node:    node.Name,
+	}
+
+	assignments, err := c.getIPAssignments()
+	if err != nil {
+		return err
+	}
+	if current, ok := assignments[key]; ok && current == wanted && !assignedIPIDs(assignments, key)[ipID] {
+		return nil
+	}
+
+	value, err := json.Marshal(wanted)
+	if err != nil {
+		return err
+	}
+
+	err = c.ipAssignments.Update(func(data map[string]string) bool {
+		changed := false
+		for k, v := range data {
+			var assignment ipAssignment
+			if k == key || json.Unmarshal([]byte(v), &assignment) != nil || assignment.ID != ipID {
+				continue
+			}
+			delete(data, k)
+			changed = true
+		}
+		if data[key] != string(value) {
+			data[key] = string(value)
+			changed = true
+		}
+		return changed
+	})
+	if err != nil {
+		klog.Errorf("could not record reserved IP %s of node %s: %v", address, node.Name, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/metrics"
+	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	ipClusterTagPrefix     = "k8s-node-coffee-cluster="
+	ipPoolTagPrefix        = "k8s-node-coffee-pool="
+	ipUnusedSinceTagPrefix = "k8s-node-coffee-unused-since="
+
+	// reservedIPReleaseInterval is how often the booked IPs are checked for release
+	reservedIPReleaseInterval = 5 * time.Minute
+)
+
+// ipOwnerTags returns the tags of the IPs booked by the cluster for a pool, or nil without cluster ID
+func ipOwnerTags(clusterID string, pool string) []string {
+	if clusterID == "" {
+		return nil
+	}
+	return []string{ipClusterTagPrefix + clusterID, ipPoolTagPrefix + pool}
+}
+
+// provisionIP books a new flexible IP for the pool in the given zone
+func (c *NodeController) provisionIP(zone scw.Zone, pool *reservedIPPool) (*instance.IP, error) {
+	resp, err := c.scwClient.Instance.CreateIP(&instance.CreateIPRequest{
+		Zone: zone,
+		Tags: pool.ownerTags,
+	})
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("booked reserved IP %s in zone %s for pool %s", resp.IP.Address.String(), zone, pool.name)
+	metrics.ReservedIPsProvisionedTotal.WithLabelValues(pool.name).Inc()
+
+	return resp.IP, nil
+}
+
+// runReservedIPReleaser periodically refreshes the reserved IPs metric and releases the booked IPs unused
+// for longer than their retention, until stopCh is closed
+func (c *NodeController) runReservedIPReleaser(stopCh <-chan struct{}) {
+	for {
+		c.refreshReservedIPsMetrics()
+
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(reservedIPReleaseInterval):
+		}
+
+		if c.getConfig().ClusterID == "" {
+			continue
+		}
+
+		err := c.releaseUnusedIPs()
+		if err != nil {
+			klog.Errorf("reserved IPs release failed: %v", err)
+		}
+	}
+}
+
+// releaseUnusedIPs tags the booked IPs with the time they were first seen unused, and deletes the ones
+// unused for longer than the retention of their pool. The retention of a pool not booking IPs anymore is the default one.
+func (c *NodeController) releaseUnusedIPs() error {
+	instanceAPI := c.scwClient.Instance
+	clusterTag := ipClusterTagPrefix + c.getConfig().ClusterID
+
+	retentions := make(map[string]time.Duration)
+	c.configMu.RLock()
+	for _, pool := range c.ipPools {
+		if pool.autoProvision != nil {
+			retentions[pool.name] = pool.autoProvision.Retention.Duration
+		}
+	}
+	c.configMu.RUnlock()
+
+	gotErr := false
+	now := time.Now()
+
+	for _, zone := range scw.AllZones {
+		ipsList, err := instanceAPI.ListIPs(&instance.ListIPsRequest{
+			Zone: zone,
+			Tags: []string{clusterTag},
+		}, scw.WithAllPages())
+		if err != nil {
+			klog.Errorf("could not list the booked IPs in zone %s: %v", zone, err)
+			gotErr = true
+			continue
+		}
+
+		for _, ip := range ipsList.IPs {
+			pool := ""
+			var unusedSince time.Time
+			tags := []string{}
+			for _, tag := range ip.Tags {
+				switch {
+				case strings.HasPrefix(tag, ipPoolTagPrefix):
+					pool = strings.TrimPrefix(tag, ipPoolTagPrefix)
+				case strings.HasPrefix(tag, ipUnusedSinceTagPrefix):
+					unusedSince, _ = time.Parse(time.RFC3339, strings.TrimPrefix(tag, ipUnusedSinceTagPrefix))
+					continue
+				}
+				tags = append(tags, tag)
+			}
+
+			switch {
+			case ip.Server != nil && len(tags) != len(ip.Tags):
+				// used again
+				err = c.setIPTags(ip, tags)
+			case ip.Server == nil && unusedSince.IsZero():
+				err = c.setIPTags(ip, append(tags, ipUnusedSinceTagPrefix+now.UTC().Format(time.RFC3339)))
+			case ip.Server == nil:
+				retention := retentions[pool]
+				if retention == 0 {
+					retention = config.DefaultReservedIPRetention
+				}
+				if now.Sub(unusedSince) < retention {
+					continue
+				}
+				err = c.releaseIP(ip, pool)
+			}
+			if err != nil {
+				gotErr = true
+			}
+		}
+	}
+
+	if gotErr {
+		return fmt.Errorf("got some errors")
+	}
+	return nil
+}
+
+func (c *NodeController) setIPTags(ip *instance.IP, tags []string) error {
+	_, err := c.scwClient.Instance.UpdateIP(&instance.UpdateIPRequest{
+		Zone: ip.Zone,
+		IP:   ip.ID,
+		Tags: &tags,
+	})
+	if err != nil {
+		klog.Errorf("could not update the tags of IP %s: %v", ip.Address.String(), err)
+		return err
+	}
+	return nil
+}
+
+// releaseIP deletes a booked IP, checking it was not attached in between
+func (c *NodeController) releaseIP(ip *instance.IP, pool string) error {
+	instanceAPI := c.scwClient.Instance
+
+	// held so that no node gets the IP between the check and the deletion
+	c.reservedIPMu.Lock()
+	defer c.reservedIPMu.Unlock()
+
+	resp, err := instanceAPI.GetIP(&instance.GetIPRequest{
+		Zone: ip.Zone,
+		IP:   ip.ID,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		klog.Errorf("could not get IP %s: %v", ip.Address.String(), err)
+		return err
+	}
+	if resp.IP.Server != nil {
+		return nil
+	}
+
+	err = instanceAPI.DeleteIP(&instance.DeleteIPRequest{
+		Zone: ip.Zone,
+		IP:   ip.ID,
+	})
+	if err != nil && !isNotFound(err) {
+		klog.Errorf("could not release IP %s: %v", ip.Address.String(), err)
+		return err
+	}
+	klog.Infof("released unused reserved IP %s of pool %s", ip.Address.String(), pool)
+	metrics.ReservedIPsReleasedTotal.WithLabelValues(pool).Inc()
+	c.refreshReservedIPsMetric(ip.Zone)
+	return nil
+}
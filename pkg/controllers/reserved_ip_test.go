@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway/fake"
+)
+
+func TestReservedIP(t *testing.T) {
+	b := fake.NewBackend()
+	server := b.AddServer("node-1", "", "51.158.1.1", "10.0.0.1")
+	ip := b.AddIP("51.15.15.15", "")
+
+	cfg := newTestConfig()
+	cfg.ReservedIPs = []string{ip.Address.String()}
+	clientset := startNodeController(t, b.Client(), cfg, newTestNode("node-1"))
+
+	waitFor(t, "the reserved IP to be attached", func() bool {
+		attached := b.IP(ip.ID).Server
+		return attached != nil && attached.ID == server.ID
+	})
+	if address := b.Server(server.ID).PublicIP.Address.String(); address != ip.Address.String() {
+		t.Errorf("expected public IP %s, got %s", ip.Address.String(), address)
+	}
+
+	zonalID := fmt.Sprintf("%s/%s", fake.DefaultZone, ip.ID)
+	waitFor(t, "the node to be labeled", func() bool {
+		node := getNode(t, clientset, "node-1")
+		return node.Labels[NodeLabelReservedIP] == "true" && node.Annotations[AnnotationReservedIPID] == zonalID
+	})
+
+	deleteNode(t, clientset, "node-1")
+	waitFor(t, "the reserved IP to be detached", func() bool {
+		return b.IP(ip.ID).Server == nil
+	})
+}
+
+func TestReservedIPPoolExhausted(t *testing.T) {
+	b := fake.NewBackend()
+	first := b.AddServer("node-1", "", "51.158.1.1", "10.0.0.1")
+	second := b.AddServer("node-2", "", "51.158.1.2", "10.0.0.2")
+	ip := b.AddIP("51.15.15.15", "")
+
+	cfg := newTestConfig()
+	cfg.ReservedIPs = []string{ip.Address.String()}
+	clientset := startNodeController(t, b.Client(), cfg, newTestNode("node-1"), newTestNode("node-2"))
+
+	waitFor(t, "the reserved IP to be attached", func() bool {
+		return b.IP(ip.ID).Server != nil
+	})
+	other := second
+	if b.IP(ip.ID).Server.ID == second.ID {
+		other = first
+	}
+	if !b.Server(other.ID).PublicIP.Dynamic {
+		t.Errorf("expected server %s to keep its dynamic IP", other.Name)
+	}
+	if node := getNode(t, clientset, other.Name); node.Labels[NodeLabelReservedIP] != "" {
+		t.Errorf("expected node %s not to be labeled", other.Name)
+	}
+}
+
+func TestReservedIPPendingTaint(t *testing.T) {
+	b := fake.NewBackend()
+	server := b.AddServer("node-1", "", "51.158.1.1", "10.0.0.1")
+	ip := b.AddIP("51.15.15.15", "")
+
+	cfg := newTestConfig()
+	cfg.ReservedIPs = []string{ip.Address.String()}
+	cfg.ReservedIPPendingTaint = true
+	// a node labeled by a previous reserved IP is not synced again by the label patch
+	node := newTestNode("node-1")
+	node.Labels = map[string]string{NodeLabelReservedIP: "true"}
+	clientset := startNodeController(t, b.Client(), cfg, node)
+
+	waitFor(t, "the reserved IP to be attached", func() bool {
+		attached := b.IP(ip.ID).Server
+		return attached != nil && attached.ID == server.ID
+	})
+	// the taint is added and removed by the same sync
+	waitFor(t, "the taint to be removed", func() bool {
+		node := getNode(t, clientset, "node-1")
+		return node.Labels[NodeLabelReservedIP] == "true" && !hasTaint(node, TaintReservedIPPending)
+	})
+}
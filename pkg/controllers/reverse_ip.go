@@ -3,156 +3,239 @@ package controllers
 import (
 	"fmt"
 	"net"
-	"time"
+	"strings"
 
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
 	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
 	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	v1 "k8s.io/api/core/v1"
 	klog "k8s.io/klog/v2"
 )
 
-const (
-	waitingPropagation = time.Second * 30
-)
+func (c *NodeController) cleanupReverseIP(node *v1.Node) error {
+	nodeName := node.Name
+	delete(c.dnsPending, nodeName)
 
-func (c *NodeController) syncReverseIP(nodeName string) error {
-	if c.reverseIPDomain == "" {
-		return nil
+	// the reverse and the IP recorded on a node being deleted
+	reverseName := node.Annotations[AnnotationReverseName]
+	reverseIP := ""
+	reverseZone := getNodeZone(node)
+	if reverseName != "" && node.Annotations[AnnotationReservedIPID] != "" {
+		id, zone, err := getZonalID(node.Annotations[AnnotationReservedIPID])
+		if err != nil {
+			klog.Warningf("ignoring invalid reserved IP annotation of node %s: %v", nodeName, err)
+		} else {
+			reverseIP = id
+			if zone != "" {
+				reverseZone = scw.Zone(zone)
+			}
+		}
 	}
 
-	klog.Infof("adding a reverse for IP on node %s", nodeName)
+	scwZone, scwZoneFound := c.getDNSZone()
 
-	_, exists, err := c.indexer.GetByKey(nodeName)
-	if err != nil {
-		klog.Errorf("could not get node %s by key: %v", nodeName, err)
-		return err
-	}
+	instanceAPI := c.scwClient.Instance
 
-	dnsAPI := dns.NewAPI(c.scwClient)
-	instanceAPI := instance.NewAPI(c.scwClient)
-
-	if !exists {
-		if c.scwZoneFound {
-			maxPage := uint32(1000)
-			records := []*dns.Record{}
-			page := int32(0)
-
-			for {
-				page = page + 1
-				listing, err := dnsAPI.ListDNSZoneRecords(&dns.ListDNSZoneRecordsRequest{
-					DNSZone:  c.scwZone,
-					Page:     &page,
-					PageSize: &maxPage,
-					Type:     "A",
-				})
-				if err != nil {
-					klog.Errorf("could not get checking record dns for node %s: %v", nodeName, err)
-					return err
-				}
-				records = append(records, listing.Records...)
-				if len(listing.Records) < int(maxPage) {
-					break
-				}
+	if scwZoneFound {
+		removed, err := c.updateNodeRecords(nodeName, scwZone, dnsRecordCommentPrefix, nil, EventReasonReverseIPFailed)
+		if err != nil {
+			return err
+		}
+		for _, record := range removed {
+			if record.Type == dns.RecordTypeA {
+				reverseIP = record.Data
 			}
+		}
+	}
 
-			var recordToDelete *dns.Record
-			for i := range records {
-				if records[i].Comment != nil && *records[i].Comment == fmt.Sprintf("k8s node %s", nodeName) {
-					recordToDelete = records[i]
-					break
-				}
-			}
+	if reverseIP != "" {
+		klog.Infof("try to remove reverse for node %s", nodeName)
+		_, err := instanceAPI.UpdateIP(&instance.UpdateIPRequest{
+			Zone:    reverseZone,
+			IP:      reverseIP,
+			Reverse: &instance.NullableStringValue{Null: true},
+		})
+		if err != nil && !isNotFound(err) {
+			klog.Errorf("could not remove reverse of IP %s for node %s: %v", reverseIP, nodeName, err)
+			c.eventf(nodeName, v1.EventTypeWarning, EventReasonReverseIPFailed, "Could not remove reverse of IP %s: %v", reverseIP, err)
+			return err
+		}
+		if err == nil {
+			c.eventf(nodeName, v1.EventTypeNormal, EventReasonReverseIPRemoved, "Removed reverse of IP %s", reverseIP)
+		}
+	}
 
-			if recordToDelete != nil {
-				klog.Infof("try to remove record dns for node %s", nodeName)
-
-				_, err := dnsAPI.UpdateDNSZoneRecords(&dns.UpdateDNSZoneRecordsRequest{
-					DNSZone: c.scwZone,
-					Changes: []*dns.RecordChange{
-						&dns.RecordChange{
-							Delete: &dns.RecordChangeDelete{
-								ID: &recordToDelete.ID,
-							},
-						},
-					},
-				})
-				if err != nil {
-					klog.Errorf("could delete record dns for node %s: %v", nodeName, err)
-					return err
-				}
-
-				klog.Infof("try to remove reverse for node %s", nodeName)
-				instanceAPI.UpdateIP(&instance.UpdateIPRequest{
-					IP:      recordToDelete.Data,
-					Reverse: &instance.NullableStringValue{Null: true},
-				})
+	return nil
+}
 
-			}
-		}
+func (c *NodeController) syncReverseIP(node *v1.Node) error {
+	nodeName := node.Name
 
-		klog.Infof("node %s was deleted, ignoring", nodeName)
-		return nil
-	}
+	klog.Infof("adding a reverse for IP on node %s", nodeName)
+
+	scwZone, scwZoneFound := c.getDNSZone()
 
-	server, err := c.getInstanceFromNodeName(nodeName)
+	instanceAPI := c.scwClient.Instance
+
+	server, err := c.getInstanceFromNode(node)
 	if err != nil {
 		klog.Errorf("could not get server %s: %v", nodeName, err)
 		return err
 	}
 
-	if server.PublicIP == nil {
-		klog.Warning("node %s does not have a public IP")
-		return nil
+	var publicIP net.IP
+	switch {
+	case server.PublicIP == nil:
+		klog.Warningf("node %s does not have a public IP", nodeName)
+	case server.PublicIP.Dynamic:
+		klog.Warningf("can't update the reverse of a dynamic IP for node %s", nodeName)
+	default:
+		publicIP = server.PublicIP.Address
 	}
 
-	if server.PublicIP.Dynamic {
-		klog.Warningf("can't update the reverse of a dynamic IP for node %s", nodeName)
-		return nil
+	ipv6 := getNodeIPv6(node)
+	if server.IPv6 != nil && server.IPv6.Address != nil {
+		ipv6 = server.IPv6.Address
 	}
 
-	if c.scwZoneFound {
-		comment := fmt.Sprintf("k8s node %s", nodeName)
-		_, err := dnsAPI.UpdateDNSZoneRecords(&dns.UpdateDNSZoneRecordsRequest{
-			DNSZone: c.scwZone,
-			Changes: []*dns.RecordChange{
-				&dns.RecordChange{
-					Add: &dns.RecordChangeAdd{
-						Records: []*dns.Record{
-							&dns.Record{
-								Data:    server.PublicIP.Address.String(),
-								Name:    fmt.Sprintf("%s.%s.", getReversePrefix(server.PublicIP.Address), c.reverseIPDomain),
-								TTL:     600,
-								Type:    "A",
-								Comment: &comment,
-							},
-						},
-					},
-				},
-			},
-		})
+	reverseName := ""
+	if publicIP != nil {
+		reverseName, err = c.getReverseName(node, server.Zone, publicIP)
 		if err != nil {
-			klog.Errorf("could not update record dns for node %s: %v", nodeName, err)
+			klog.Errorf("could not get the reverse name of IP %s for node %s: %v", publicIP.String(), nodeName, err)
+			c.eventf(nodeName, v1.EventTypeWarning, EventReasonReverseIPFailed, "Could not get the reverse name of IP %s: %v", publicIP.String(), err)
+			return err
+		}
+	}
+
+	// the IPv6 of the server only gets its AAAA record, its reverse can't be set through the Instance API
+	ipv6Name := ""
+	if ipv6 != nil {
+		ipv6Name, err = c.getReverseName(node, server.Zone, ipv6)
+		if err != nil {
+			// a template made for the reserved IPv4 must not prevent their reverse
+			klog.Warningf("could not get the reverse name of IP %s for node %s: %v", ipv6.String(), nodeName, err)
+			c.eventf(nodeName, v1.EventTypeWarning, EventReasonReverseIPFailed, "Could not get the reverse name of IP %s: %v", ipv6.String(), err)
+			ipv6Name = ""
+		}
+	}
+
+	if scwZoneFound && (reverseName != "" || ipv6Name != "") {
+		records := []nodeDNSRecord{}
+		if reverseName != "" {
+			records = append(records, nodeDNSRecord{zone: scwZone, name: reverseName, ip: publicIP})
+		}
+		if ipv6Name != "" {
+			records = append(records, nodeDNSRecord{zone: scwZone, name: ipv6Name, ip: ipv6})
+		}
+		err = c.ensureReverseRecords(nodeName, scwZone, records)
+		if err != nil {
+			return err
+		}
+	}
+
+	if publicIP == nil {
+		return nil
+	}
+
+	// the reverse is only accepted once the name resolves to the IP, which never happens in dry run
+	if node.Annotations[AnnotationReverseName] != reverseName && !c.scwClient.DryRun {
+		propagated, err := c.checkDNSPropagation(node, reverseName, publicIP)
+		if err != nil || !propagated {
 			return err
 		}
-		klog.Infof("waiting propagation for record dns for node %s", nodeName)
-		time.Sleep(waitingPropagation)
 	}
 
 	_, err = instanceAPI.UpdateIP(&instance.UpdateIPRequest{
-		IP: server.PublicIP.Address.String(),
+		Zone: server.Zone,
+		IP:   publicIP.String(),
 		Reverse: &instance.NullableStringValue{
-			Value: fmt.Sprintf("%s.%s", getReversePrefix(server.PublicIP.Address), c.reverseIPDomain),
+			Value: reverseName,
 		},
 	})
 	if err != nil {
-		klog.Errorf("could not update reverse on IP %s for node %s: %v", server.PublicIP.Address.String(), nodeName, err)
+		klog.Errorf("could not update reverse on IP %s for node %s: %v", publicIP.String(), nodeName, err)
+		c.eventf(nodeName, v1.EventTypeWarning, EventReasonReverseIPFailed, "Could not update reverse of IP %s: %v", publicIP.String(), err)
+		return err
+	}
+
+	// the reverse is not set in dry-run mode, so the node is left as is
+	if c.scwClient.DryRun {
+		klog.Infof("dry-run: would set reverse of IP %s to %s for node %s", publicIP.String(), reverseName, nodeName)
+		return nil
+	}
+	c.eventf(nodeName, v1.EventTypeNormal, EventReasonReverseIPUpdated, "Updated reverse of IP %s to %s", publicIP.String(), reverseName)
+
+	err = c.setNodeAnnotations(node, map[string]string{
+		AnnotationReverseName: reverseName,
+	})
+	if err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// ensureReverseRecords sets the records of the reverse names in the DNS zone, and removes the other records of the node
+func (c *NodeController) ensureReverseRecords(nodeName string, scwZone string, records []nodeDNSRecord) error {
+	_, err := c.updateNodeRecords(nodeName, scwZone, dnsRecordCommentPrefix, records, EventReasonReverseIPFailed)
+	return err
+}
+
+// getReverseName renders the reverse name of the IP of the node, which must be in the reverse domain
+// for its record to be added in the Scaleway DNS zone
+func (c *NodeController) getReverseName(node *v1.Node, zone scw.Zone, ip net.IP) (string, error) {
+	domain := c.getConfig().ReverseIPDomain
+	poolName, index := c.getReservedIPIndex(node, ip)
+
+	data := config.ReverseName{
+		IP:         ip.String(),
+		ReversedIP: getReversePrefix(ip),
+		NodeName:   node.Name,
+		Pool:       poolName,
+		Index:      index,
+		Zone:       zone.String(),
+		Labels:     node.Labels,
+		Domain:     domain,
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		data.Octets = []int{int(ip4[0]), int(ip4[1]), int(ip4[2]), int(ip4[3])}
+	}
+
+	c.configMu.RLock()
+	tmpl := c.reverseName
+	c.configMu.RUnlock()
+
+	name, err := config.RenderReverseName(tmpl, data)
+	if err != nil {
+		return "", err
+	}
+	if _, found := c.getDNSZone(); found && name != domain && !strings.HasSuffix(name, "."+domain) {
+		return "", fmt.Errorf("reverse name %s is not in domain %s", name, domain)
+	}
+	return name, nil
+}
+
+// getReversePrefix returns the label of the IP in the reverse domain: the reversed octets of an IPv4
+// (134-134-15-15), or the reversed nibbles of an IPv6 (1-0-0-0-...-8-b-d-0-1-0-0-2), both with dashes
 func getReversePrefix(ip net.IP) string {
-	ip = ip.To4()
-	// TODO better handling, error prone
-	return fmt.Sprintf("%d-%d-%d-%d", ip[3], ip[2], ip[1], ip[0])
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d-%d-%d-%d", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+
+	ip16 := ip.To16()
+	nibbles := make([]string, 0, 2*net.IPv6len)
+	for i := net.IPv6len - 1; i >= 0; i-- {
+		nibbles = append(nibbles, fmt.Sprintf("%x", ip16[i]&0xf), fmt.Sprintf("%x", ip16[i]>>4))
+	}
+	return strings.Join(nibbles, "-")
+}
+
+// dnsRecordType returns the type of the records of the IP, AAAA for an IPv6
+func dnsRecordType(ip net.IP) dns.RecordType {
+	if ip.To4() == nil {
+		return dns.RecordTypeAAAA
+	}
+	return dns.RecordTypeA
 }
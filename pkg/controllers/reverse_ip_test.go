@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway/fake"
+	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDNS answers the A and AAAA queries with the records of the zone of the fake backend, for the
+// reverses to be accepted once their record is set. It returns the address to use as dnsResolver.
+func serveDNS(t *testing.T, b *fake.Backend, zone string) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen for DNS queries: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var msg dnsmessage.Message
+			if msg.Unpack(buf[:n]) != nil || len(msg.Questions) != 1 {
+				continue
+			}
+			question := msg.Questions[0]
+			msg.Header.Response = true
+			msg.Header.Authoritative = true
+
+			name := strings.TrimSuffix(strings.ToLower(question.Name.String()), ".")
+			for _, record := range b.DNSRecords(zone) {
+				if !isRecordName(record.Name, zone, name) {
+					continue
+				}
+				header := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: record.TTL}
+				ip := net.ParseIP(record.Data)
+				switch {
+				case question.Type == dnsmessage.TypeA && record.Type == dns.RecordTypeA:
+					header.Type = dnsmessage.TypeA
+					a := dnsmessage.AResource{}
+					copy(a.A[:], ip.To4())
+					msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: header, Body: &a})
+				case question.Type == dnsmessage.TypeAAAA && record.Type == dns.RecordTypeAAAA:
+					header.Type = dnsmessage.TypeAAAA
+					aaaa := dnsmessage.AAAAResource{}
+					copy(aaaa.AAAA[:], ip.To16())
+					msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: header, Body: &aaaa})
+				}
+			}
+
+			resp, err := msg.Pack()
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestReverseIP(t *testing.T) {
+	b := fake.NewBackend()
+	server := b.AddServer("node-1", "", "51.158.1.1", "10.0.0.1")
+	b.SetServerIPv6(server.ID, "2001:db8::1")
+	ip := b.AddIP("51.15.15.15", "")
+	b.AddDNSZone("example.com", "")
+
+	cfg := newTestConfig()
+	cfg.ReservedIPs = []string{ip.Address.String()}
+	cfg.ReverseIPDomain = "example.com"
+	cfg.DNSResolver = serveDNS(t, b, "example.com")
+	clientset := startNodeController(t, b.Client(), cfg, newTestNode("node-1"))
+
+	reverseName := "15-15-15-51.example.com"
+	waitFor(t, "the reverse to be set", func() bool {
+		reverse := b.IP(ip.ID).Reverse
+		return reverse != nil && *reverse == reverseName
+	})
+	waitFor(t, "the node to be annotated", func() bool {
+		return getNode(t, clientset, "node-1").Annotations[AnnotationReverseName] == reverseName
+	})
+
+	wanted := map[string]string{
+		reverseName + ".": "51.15.15.15",
+		"1-0-0-0-0-0-0-0-0-0-0-0-0-0-0-0-0-0-0-0-0-0-0-0-8-b-d-0-1-0-0-2.example.com.": "2001:db8::1",
+	}
+	records := b.DNSRecords("example.com")
+	if len(records) != len(wanted) {
+		t.Fatalf("expected %d records, got %d", len(wanted), len(records))
+	}
+	for _, record := range records {
+		if wanted[record.Name] != record.Data {
+			t.Errorf("unexpected record %s %s %s", record.Name, record.Type, record.Data)
+		}
+		if record.Comment == nil || *record.Comment != "k8s node node-1" {
+			t.Errorf("unexpected comment of record %s", record.Name)
+		}
+	}
+
+	deleteNode(t, clientset, "node-1")
+	waitFor(t, "the records to be removed", func() bool {
+		return len(b.DNSRecords("example.com")) == 0
+	})
+	if reverse := b.IP(ip.ID).Reverse; reverse != nil {
+		t.Errorf("expected the reverse to be removed, got %s", *reverse)
+	}
+}
+
+func TestReverseIPClusterID(t *testing.T) {
+	b := fake.NewBackend()
+	b.AddServer("node-1", "", "51.158.1.1", "10.0.0.1")
+	ip := b.AddIP("51.15.15.15", "")
+	b.AddDNSZone("example.com", "")
+
+	// the record of the same node of another cluster is left untouched
+	comment := "k8s cluster other node node-1"
+	_, err := b.Client().Domain.UpdateDNSZoneRecords(&dns.UpdateDNSZoneRecordsRequest{
+		DNSZone: "example.com",
+		Changes: []*dns.RecordChange{
+			{
+				Add: &dns.RecordChangeAdd{
+					Records: []*dns.Record{
+						{Name: "15-15-15-52.example.com.", Type: dns.RecordTypeA, Data: "52.15.15.15", TTL: dnsRecordTTL, Comment: &comment},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not add record: %v", err)
+	}
+
+	cfg := newTestConfig()
+	cfg.ClusterID = "test"
+	cfg.ReservedIPs = []string{ip.Address.String()}
+	cfg.ReverseIPDomain = "example.com"
+	cfg.DNSResolver = serveDNS(t, b, "example.com")
+	clientset := startNodeController(t, b.Client(), cfg, newTestNode("node-1"))
+
+	waitFor(t, "the reverse to be set", func() bool {
+		return b.IP(ip.ID).Reverse != nil
+	})
+
+	owned := 0
+	for _, record := range b.DNSRecords("example.com") {
+		if *record.Comment == "k8s cluster test node node-1" {
+			owned++
+		}
+	}
+	if owned != 1 || len(b.DNSRecords("example.com")) != 2 {
+		t.Errorf("expected one record owned by the cluster next to the other one, got %d of %d", owned, len(b.DNSRecords("example.com")))
+	}
+
+	deleteNode(t, clientset, "node-1")
+	waitFor(t, "the record of the cluster to be removed", func() bool {
+		return len(b.DNSRecords("example.com")) == 1
+	})
+	if *b.DNSRecords("example.com")[0].Comment != comment {
+		t.Errorf("expected the record of the other cluster to be kept")
+	}
+}
@@ -11,33 +11,15 @@ import (
 	klog "k8s.io/klog/v2"
 )
 
-func (c *SvcController) syncSecurityGroup(svcName string) error {
-	if len(c.securityGroupIDs) == 0 {
-		return nil
-	}
-
-	svcObj, exists, err := c.indexer.GetByKey(svcName)
-	if err != nil {
-		klog.Errorf("could not get service %s by key: %v", svcName, err)
-		return err
-	}
-
-	if !exists {
-		klog.Warningf("service %s does not exists, ignoring", svcName)
-		return nil
-	}
-
-	svc, ok := svcObj.(*v1.Service)
-	if !ok {
-		klog.Errorf("could not get service %s from obejct", svcName)
-		return fmt.Errorf("could not get service %s from obejct", svcName)
-	}
+// syncSecurityGroup has no cleanup for services, as rules can't be told apart from the ones created by hand
+func (c *SvcController) syncSecurityGroup(svc *v1.Service) error {
+	svcName := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
 
-	instanceAPI := instance.NewAPI(c.scwClient)
+	instanceAPI := c.scwClient.Instance
 
 	gotErr := false
 
-	for _, id := range c.securityGroupIDs {
+	for _, id := range c.getConfig().SecurityGroupIDs {
 		klog.Infof("syncing security group %s with service %s", id, svcName)
 		sgID, zone, err := getZonalID(id)
 		if err != nil {
@@ -56,21 +38,31 @@ func (c *SvcController) syncSecurityGroup(svcName string) error {
 			continue
 		}
 
+		// the node ports are opened to every IPv4, and to every IPv6 by another rule when enabled
+		ipRanges := []net.IPNet{
+			{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+		}
+		if c.getConfig().SecurityGroupNodePortsIPv6 {
+			ipRanges = append(ipRanges, net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)})
+		}
+
 		for _, port := range svc.Spec.Ports {
-			found := false
 			if port.NodePort == 0 {
 				continue
 			}
+			found := map[bool]bool{}
 			for _, sgRule := range sgRulesResp.Rules {
 				if sgRule.Action != instance.SecurityGroupRuleActionAccept || sgRule.Direction != instance.SecurityGroupRuleDirectionInbound || sgRule.Protocol.String() != string(port.Protocol) {
 					continue
 				}
 				if sgRule.DestPortFrom != nil && sgRule.DestPortTo != nil && *sgRule.DestPortFrom == *sgRule.DestPortTo && *sgRule.DestPortFrom == uint32(port.NodePort) {
-					found = true
-					break
+					found[sgRule.IPRange.IP.To4() != nil] = true
 				}
 			}
-			if !found {
+			for _, ipRange := range ipRanges {
+				if found[ipRange.IP.To4() != nil] {
+					continue
+				}
 				_, err := instanceAPI.CreateSecurityGroupRule(&instance.CreateSecurityGroupRuleRequest{
 					SecurityGroupID: sgID,
 					Zone:            scw.Zone(zone),
@@ -78,19 +70,18 @@ func (c *SvcController) syncSecurityGroup(svcName string) error {
 					Direction:       instance.SecurityGroupRuleDirectionInbound,
 					Protocol:        instance.SecurityGroupRuleProtocol(port.Protocol),
 					IPRange: scw.IPNet{
-						IPNet: net.IPNet{
-							IP:   net.ParseIP("0.0.0.0"),
-							Mask: net.IPv4Mask(0, 0, 0, 0), // TODO better idea?
-						},
+						IPNet: ipRange,
 					},
 					DestPortFrom: scw.Uint32Ptr(uint32(port.NodePort)),
 					DestPortTo:   scw.Uint32Ptr(uint32(port.NodePort)),
 				})
 				if err != nil {
-					klog.Errorf("could not create security group rule for svc %s port %s: %v", svcName, port.NodePort, err)
+					klog.Errorf("could not create security group rule for svc %s port %d from %s: %v", svcName, port.NodePort, ipRange.String(), err)
+					c.recorder.Eventf(svc, v1.EventTypeWarning, EventReasonSecurityGroupRuleFailed, "Could not allow %s node port %d from %s in security group %s: %v", port.Protocol, port.NodePort, ipRange.String(), id, err)
 					gotErr = true
 					continue
 				}
+				c.recorder.Eventf(svc, v1.EventTypeNormal, EventReasonSecurityGroupRuleAdded, "Allowed %s node port %d from %s in security group %s", port.Protocol, port.NodePort, ipRange.String(), id)
 			}
 		}
 	}
@@ -103,33 +94,76 @@ func (c *SvcController) syncSecurityGroup(svcName string) error {
 
 }
 
-func (c *NodeController) syncSecurityGroup(nodeName string) error {
-	if len(c.securityGroupIDs) == 0 {
-		return nil
+func (c *NodeController) syncSecurityGroup(node *v1.Node) error {
+	groups, syncErr := c.updateSecurityGroups(node, true)
+
+	// the rules are not added in dry-run mode, so the node is left as is
+	if c.scwClient.DryRun {
+		return syncErr
 	}
 
-	_, exists, err := c.indexer.GetByKey(nodeName)
+	err := c.setNodeAnnotations(node, map[string]string{
+		AnnotationSecurityGroups: joinAnnotation(groups),
+	})
 	if err != nil {
-		klog.Errorf("could not get node %s by key: %v", nodeName, err)
 		return err
 	}
 
-	server, err := c.getInstanceFromNodeName(nodeName)
+	return syncErr
+}
+
+func (c *NodeController) cleanupSecurityGroup(node *v1.Node) error {
+	_, err := c.updateSecurityGroups(node, false)
+	return err
+}
+
+// updateSecurityGroups adds the node IPs to the security groups if it exists, and removes them otherwise.
+// It returns the security groups (zone/id) the node IPs are in.
+func (c *NodeController) updateSecurityGroups(node *v1.Node, exists bool) ([]string, error) {
+	nodeName := node.Name
+
+	var nodeIPs []net.IP
+	var serverZone scw.Zone
+
+	server, err := c.getInstanceFromNode(node)
 	if err != nil {
 		klog.Warningf("could not get instance %s: %v", nodeName, err)
 		if exists {
-			return err
+			return splitAnnotation(node.Annotations[AnnotationSecurityGroups]), err
+		}
+		// fallback on the addresses and zone of a node being deleted
+		publicIP, privateIP := getNodeAddresses(node)
+		nodeIPs = appendIPs(nodeIPs, privateIP, publicIP, getNodeIPv6(node))
+		serverZone = getNodeZone(node)
+		if len(nodeIPs) == 0 {
+			// end here if node does not exists anymore and we couldn't get the server
+			// in order to delete the old IP
+			return nil, nil
+		}
+	} else {
+		serverZone = server.Zone
+		if server.PrivateIP != nil && *server.PrivateIP != "" {
+			nodeIPs = appendIPs(nodeIPs, net.ParseIP(*server.PrivateIP))
+		}
+		if server.PublicIP != nil {
+			nodeIPs = appendIPs(nodeIPs, server.PublicIP.Address)
+		}
+		if server.IPv6 != nil {
+			nodeIPs = appendIPs(nodeIPs, server.IPv6.Address)
 		}
-		// end here if node does not exists anymore and we couldn't get the server
-		// in order to delete the old IP
-		return nil
 	}
 
-	instanceAPI := instance.NewAPI(c.scwClient)
+	instanceAPI := c.scwClient.Instance
 
 	gotErr := false
+	groups := []string{}
+
+	sgIDs := c.getConfig().SecurityGroupIDs
+	if !exists {
+		sgIDs = withRecordedIDs(sgIDs, node, AnnotationSecurityGroups)
+	}
 
-	for _, id := range c.securityGroupIDs {
+	for _, id := range sgIDs {
 		klog.Infof("syncing security group %s with node %s", id, nodeName)
 		sgID, zone, err := getZonalID(id)
 		if err != nil {
@@ -137,34 +171,40 @@ func (c *NodeController) syncSecurityGroup(nodeName string) error {
 			gotErr = true
 			continue
 		}
-		if zone != "" && zone != server.Zone.String() {
-			klog.Warningf("ignoring security group %s as it's not in the same zone as the node %s", sgID, nodeName)
-			continue
+		recorded := recordedIDs(node, AnnotationSecurityGroups, sgID)
+		// a group with a zone is used for the nodes of every zone: the rules only match IPs, so a group of
+		// another zone allows the node too. A group without zone is looked up in the zone of the node.
+		sgZone := scw.Zone(zone)
+		if sgZone == "" {
+			sgZone = serverZone
 		}
 
 		sgRulesResp, err := instanceAPI.ListSecurityGroupRules(&instance.ListSecurityGroupRulesRequest{
 			SecurityGroupID: sgID,
-			Zone:            server.Zone,
+			Zone:            sgZone,
 		}, scw.WithAllPages())
+		if err != nil && zone == "" && serverZone != "" && isNotFound(err) {
+			klog.Warningf("security group %s not found in zone %s of node %s, its zone must be set if it is in another one", sgID, serverZone, nodeName)
+			c.eventf(nodeName, v1.EventTypeWarning, EventReasonZoneMismatch, "Security group %s was not found in zone %s, set its zone in the config (<zone>/<id>) if it is in another one", sgID, serverZone)
+			groups = append(groups, recorded...)
+			continue
+		}
 		if err != nil {
 			klog.Errorf("could not list rules for security group %s: %v", sgID, err)
+			groups = append(groups, recorded...)
 			gotErr = true
 			continue
 		}
 
 		toDelete := []string{}
-		foundPrivate := false
-		foundPublic := false
+		found := make([]bool, len(nodeIPs))
 
 		for _, sgRule := range sgRulesResp.Rules {
-			if server.PublicIP != nil && sgRule.IPRange.IP.Equal(server.PublicIP.Address) {
-				foundPublic = true
-				if !exists {
-					toDelete = append(toDelete, sgRule.ID)
+			for i, ip := range nodeIPs {
+				if !sgRule.IPRange.IP.Equal(ip) {
+					continue
 				}
-			}
-			if server.PrivateIP != nil && *server.PrivateIP != "" && sgRule.IPRange.IP.Equal(net.ParseIP(*server.PrivateIP)) {
-				foundPrivate = true
+				found[i] = true
 				if !exists {
 					toDelete = append(toDelete, sgRule.ID)
 				}
@@ -173,52 +213,62 @@ func (c *NodeController) syncSecurityGroup(nodeName string) error {
 
 		for _, delID := range toDelete {
 			err := instanceAPI.DeleteSecurityGroupRule(&instance.DeleteSecurityGroupRuleRequest{
-				Zone:                server.Zone,
+				Zone:                sgZone,
 				SecurityGroupID:     sgID,
 				SecurityGroupRuleID: delID,
 			})
 			if err != nil {
 				klog.Errorf("could not delete security group rule %s for SG %s: %v", delID, sgID, err)
+				c.eventf(nodeName, v1.EventTypeWarning, EventReasonSecurityGroupRuleFailed, "Could not remove rule %s from security group %s: %v", delID, id, err)
 				gotErr = true
 				continue
 			}
+			c.eventf(nodeName, v1.EventTypeNormal, EventReasonSecurityGroupRuleRemoved, "Removed rule %s from security group %s", delID, id)
 		}
 
 		toAdd := []net.IP{}
-		if !foundPrivate && exists {
-			toAdd = append(toAdd, net.ParseIP(*server.PrivateIP))
-		}
-		if !foundPublic && exists {
-			toAdd = append(toAdd, server.PublicIP.Address)
+		for i, ip := range nodeIPs {
+			if !found[i] && exists {
+				toAdd = append(toAdd, ip)
+			}
 		}
 
+		addErr := false
 		for _, ip := range toAdd {
 			_, err := instanceAPI.CreateSecurityGroupRule(&instance.CreateSecurityGroupRuleRequest{
 				SecurityGroupID: sgID,
-				Zone:            server.Zone,
+				Zone:            sgZone,
 				Action:          instance.SecurityGroupRuleActionAccept,
 				Direction:       instance.SecurityGroupRuleDirectionInbound,
 				Protocol:        instance.SecurityGroupRuleProtocolANY,
 				IPRange: scw.IPNet{
-					IPNet: net.IPNet{
-						IP:   ip,
-						Mask: net.IPv4Mask(255, 255, 255, 255), // TODO better idea?
-					},
+					IPNet: hostIPNet(ip),
 				},
 			})
 			if err != nil {
 				klog.Errorf("could not add security group rule for node %s on %s: %v", nodeName, sgID, err)
+				c.eventf(nodeName, v1.EventTypeWarning, EventReasonSecurityGroupRuleFailed, "Could not allow IP %s in security group %s/%s: %v", ip.String(), sgZone, sgID, err)
+				addErr = true
 				gotErr = true
 				continue
 			}
+			c.eventf(nodeName, v1.EventTypeNormal, EventReasonSecurityGroupRuleAdded, "Allowed IP %s in security group %s/%s", ip.String(), sgZone, sgID)
+		}
+
+		if exists {
+			if addErr {
+				groups = append(groups, recorded...)
+			} else {
+				groups = append(groups, fmt.Sprintf("%s/%s", sgZone, sgID))
+			}
 		}
 	}
 
 	if gotErr {
-		return fmt.Errorf("got some errors")
+		return groups, fmt.Errorf("got some errors")
 	}
 
-	return nil
+	return groups, nil
 }
 
 func getZonalID(r string) (string, string, error) {
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway/fake"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const testSecurityGroupID = "33333333-3333-4333-8333-333333333333"
+
+// securityGroupRanges returns the sorted IP ranges of the rules of the security group
+func securityGroupRanges(b *fake.Backend, id string) []string {
+	ranges := []string{}
+	for _, rule := range b.SecurityGroupRules(id) {
+		ranges = append(ranges, rule.IPRange.String())
+	}
+	sort.Strings(ranges)
+	return ranges
+}
+
+func TestSecurityGroupNodes(t *testing.T) {
+	b := fake.NewBackend()
+	server := b.AddServer("node-1", "", "51.158.1.1", "10.0.0.1")
+	b.SetServerIPv6(server.ID, "2001:db8::1")
+	b.AddSecurityGroup(testSecurityGroupID, "")
+
+	cfg := newTestConfig()
+	cfg.SecurityGroupIDs = []string{testSecurityGroupID}
+	clientset := startNodeController(t, b.Client(), cfg, newTestNode("node-1"))
+
+	wanted := []string{"10.0.0.1/32", "2001:db8::1/128", "51.158.1.1/32"}
+	waitFor(t, "the rules of the node to be added", func() bool {
+		return len(b.SecurityGroupRules(testSecurityGroupID)) == len(wanted)
+	})
+	ranges := securityGroupRanges(b, testSecurityGroupID)
+	for i := range wanted {
+		if ranges[i] != wanted[i] {
+			t.Errorf("expected rules %v, got %v", wanted, ranges)
+			break
+		}
+	}
+
+	deleteNode(t, clientset, "node-1")
+	waitFor(t, "the rules of the node to be removed", func() bool {
+		return len(b.SecurityGroupRules(testSecurityGroupID)) == 0
+	})
+}
+
+func TestSecurityGroupServices(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "default",
+		},
+		Spec: v1.ServiceSpec{
+			Type: v1.ServiceTypeNodePort,
+			Ports: []v1.ServicePort{
+				{Protocol: v1.ProtocolTCP, Port: 80, NodePort: 30080},
+			},
+		},
+	}
+
+	for _, tt := range []struct {
+		name   string
+		ipv6   bool
+		wanted []string
+	}{
+		{name: "ipv4", wanted: []string{"0.0.0.0/0"}},
+		{name: "dual-stack", ipv6: true, wanted: []string{"0.0.0.0/0", "::/0"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := fake.NewBackend()
+			b.AddSecurityGroup(testSecurityGroupID, "")
+
+			cfg := newTestConfig()
+			cfg.SecurityGroupIDs = []string{testSecurityGroupID}
+			cfg.SecurityGroupNodePortsIPv6 = tt.ipv6
+			startSvcController(t, b.Client(), cfg, svc.DeepCopy())
+
+			waitFor(t, "the rules of the node port to be added", func() bool {
+				return len(b.SecurityGroupRules(testSecurityGroupID)) >= len(tt.wanted)
+			})
+			ranges := securityGroupRanges(b, testSecurityGroupID)
+			if len(ranges) != len(tt.wanted) {
+				t.Fatalf("expected rules %v, got %v", tt.wanted, ranges)
+			}
+			for i := range tt.wanted {
+				if ranges[i] != tt.wanted[i] {
+					t.Errorf("expected rules %v, got %v", tt.wanted, ranges)
+					break
+				}
+			}
+			for _, rule := range b.SecurityGroupRules(testSecurityGroupID) {
+				if rule.DestPortFrom == nil || *rule.DestPortFrom != 30080 {
+					t.Errorf("expected a rule on node port 30080, got %v", rule.DestPortFrom)
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityGroupZones(t *testing.T) {
+	const otherGroupID = "44444444-4444-4444-8444-444444444444"
+
+	b := fake.NewBackend()
+	server := b.AddServer("node-1", scw.ZoneFrPar2, "51.158.1.1", "10.0.0.1")
+	b.AddSecurityGroup(testSecurityGroupID, scw.ZoneFrPar1)
+	b.AddSecurityGroup(otherGroupID, scw.ZoneFrPar1)
+
+	cfg := newTestConfig()
+	// the group with its zone allows the node of another zone, the one without zone is only looked up in
+	// the zone of the node
+	cfg.SecurityGroupIDs = []string{fmt.Sprintf("%s/%s", scw.ZoneFrPar1, testSecurityGroupID), otherGroupID}
+	node := newTestNode("node-1")
+	node.Spec.ProviderID = fmt.Sprintf("scaleway://instance/%s/%s", scw.ZoneFrPar2, server.ID)
+	c, clientset := newTestNodeController(t, b.Client(), cfg, node)
+	// the fake clientset rejects the events of the nodes, which have no namespace
+	recorder := record.NewFakeRecorder(100)
+	c.recorder = recorder
+	runNodeController(t, c)
+
+	waitFor(t, "the rules of the node to be added to the group of the other zone", func() bool {
+		return len(b.SecurityGroupRules(testSecurityGroupID)) == 2
+	})
+	waitFor(t, "a zone mismatch event for the group without zone", func() bool {
+		for len(recorder.Events) != 0 {
+			if strings.HasPrefix(<-recorder.Events, v1.EventTypeWarning+" "+EventReasonZoneMismatch) {
+				return true
+			}
+		}
+		return false
+	})
+	if rules := b.SecurityGroupRules(otherGroupID); len(rules) != 0 {
+		t.Errorf("expected no rule in the group without zone, got %d", len(rules))
+	}
+	wanted := fmt.Sprintf("%s/%s", scw.ZoneFrPar1, testSecurityGroupID)
+	waitFor(t, "the node to be annotated", func() bool {
+		return getNode(t, clientset, "node-1").Annotations[AnnotationSecurityGroups] == wanted
+	})
+
+	deleteNode(t, clientset, "node-1")
+	waitFor(t, "the rules of the node to be removed", func() bool {
+		return len(b.SecurityGroupRules(testSecurityGroupID)) == 0
+	})
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	klog "k8s.io/klog/v2"
+)
+
+// featureLabels and featureAnnotations are what the builtin features record on a node,
+// removed once the node is excluded from the feature
+var (
+	featureLabels = map[string][]string{
+		FeatureReservedIP: {NodeLabelReservedIP},
+	}
+	featureAnnotations = map[string][]string{
+		FeatureReservedIP:    {AnnotationReservedIPID},
+		FeatureReverseIP:     {AnnotationReverseName},
+		FeatureDatabaseACLs:  {AnnotationDatabases},
+		FeatureRedisACLs:     {AnnotationRedisClusters},
+		FeatureSecurityGroup: {AnnotationSecurityGroups},
+	}
+)
+
+// selectsNode returns whether the feature applies to the node, which is the case of every node without selector
+func (c *NodeController) selectsNode(feature string, node *v1.Node) bool {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	selector, ok := c.nodeSelectors[feature]
+	if !ok {
+		return true
+	}
+	return selector.Matches(labels.Set(node.Labels))
+}
+
+// warnUnknownSelectors logs the node selectors not matching any registered feature
+func (c *NodeController) warnUnknownSelectors() {
+	for feature := range c.getConfig().NodeSelectors {
+		found := false
+		for _, s := range c.syncers {
+			if s.Name() == feature {
+				found = true
+				break
+			}
+		}
+		if !found {
+			klog.Warningf("ignoring the node selector of unknown feature %s", feature)
+		}
+	}
+}
+
+// clearFeatureState removes what a builtin feature recorded on a node excluded from it
+func (c *NodeController) clearFeatureState(node *v1.Node, feature string) error {
+	labelValues := make(map[string]*string)
+	for _, label := range featureLabels[feature] {
+		labelValues[label] = nil
+	}
+	annotationValues := make(map[string]*string)
+	for _, annotation := range featureAnnotations[feature] {
+		annotationValues[annotation] = nil
+	}
+	return c.patchNodeMetadata(node, labelValues, annotationValues)
+}
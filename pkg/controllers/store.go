@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// configMapStoreMaxAge is how long the copy of the content is used before reading the ConfigMap again,
+// for the edits made outside of the controller to be seen
+const configMapStoreMaxAge = time.Minute
+
+// configMapStore persists a string map in a ConfigMap, keeping a copy of the last known content
+type configMapStore struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	data     map[string]string
+}
+
+func newConfigMapStore(clientset kubernetes.Interface, namespace string, name string) *configMapStore {
+	return &configMapStore{
+		clientset: clientset,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// Get returns a copy of the content of the store, reading the ConfigMap when the copy is older than configMapStoreMaxAge
+func (s *configMapStore) Get() (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.loadedAt.IsZero() || time.Since(s.loadedAt) > configMapStoreMaxAge {
+		cm, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(context.Background(), s.name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		s.data = nil
+		if err == nil {
+			s.data = cm.Data
+		}
+		s.loadedAt = time.Now()
+	}
+
+	data := make(map[string]string, len(s.data))
+	for key, value := range s.data {
+		data[key] = value
+	}
+	return data, nil
+}
+
+// Update applies mutate to the content of the ConfigMap, creating it if needed.
+// mutate returns false when it has nothing to change, and may be called several times on conflicts.
+func (s *configMapStore) Update(mutate func(data map[string]string) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.namespace)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(context.Background(), s.name, metav1.GetOptions{})
+		create := errors.IsNotFound(err)
+		if create {
+			cm = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      s.name,
+					Namespace: s.namespace,
+				},
+			}
+		} else if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+
+		if !mutate(cm.Data) {
+			s.data = cm.Data
+			s.loadedAt = time.Now()
+			return nil
+		}
+
+		if create {
+			cm, err = configMaps.Create(context.Background(), cm, metav1.CreateOptions{})
+			if errors.IsAlreadyExists(err) {
+				// created in between, retry as a conflict
+				return errors.NewConflict(v1.Resource("configmaps"), s.name, err)
+			}
+		} else {
+			cm, err = configMaps.Update(context.Background(), cm, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+
+		s.data = cm.Data
+		s.loadedAt = time.Now()
+		return nil
+	})
+}
+
+// Reset drops the copy of the content, for the next Get to read the ConfigMap
+func (s *configMapStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loadedAt = time.Time{}
+	s.data = nil
+}
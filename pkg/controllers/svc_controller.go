@@ -1,26 +1,35 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"strings"
 	"time"
 
-	"github.com/scaleway/scaleway-sdk-go/scw"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway"
 	"k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 	klog "k8s.io/klog/v2"
 )
 
-func NewSvcController(clientset *kubernetes.Clientset) (*SvcController, error) {
-	svcListWatcher := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "services", "", fields.Everything())
+func NewSvcController(clientset kubernetes.Interface, scwClient *scaleway.Client, cfg *config.Config) (*SvcController, error) {
+	svcListWatcher := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (k8sruntime.Object, error) {
+			return clientset.CoreV1().Services(metav1.NamespaceAll).List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return clientset.CoreV1().Services(metav1.NamespaceAll).Watch(context.Background(), options)
+		},
+	}
 
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "service")
 
 	indexer, informer := cache.NewIndexerInformer(svcListWatcher, &v1.Service{}, 0, cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
@@ -42,41 +51,64 @@ func NewSvcController(clientset *kubernetes.Clientset) (*SvcController, error) {
 		},
 	}, cache.Indexers{})
 
-	scwClient, err := scw.NewClient(scw.WithEnv())
-	if err != nil {
-		return nil, err
-	}
-
 	controller := &SvcController{
-		indexer:       indexer,
-		informer:      informer,
-		queue:         queue,
-		scwClient:     scwClient,
-		numberRetries: defaultNumberRetries,
+		indexer:   indexer,
+		informer:  informer,
+		queue:     queue,
+		scwClient: scwClient,
+		recorder:  newEventRecorder(clientset),
 	}
 
-	// TODO handle validation here ?
-	if os.Getenv(SecurityGroupIDs) != "" {
-		controller.securityGroupIDs = strings.Split(os.Getenv(SecurityGroupIDs), ",")
+	controller.syncers = []SvcSyncer{
+		&svcSyncerFuncs{
+			name:    FeatureSecurityGroup,
+			enabled: func() bool { return len(controller.getConfig().SecurityGroupIDs) != 0 },
+			sync:    controller.syncSecurityGroup,
+		},
 	}
 
+	controller.setConfig(cfg)
+
 	return controller, nil
 }
 
-func (c *SvcController) syncNeeded(nodeName string) error {
-	var errs []error
-
-	err := c.syncSecurityGroup(nodeName)
+func (c *SvcController) syncNeeded(svcName string) error {
+	svcObj, exists, err := c.indexer.GetByKey(svcName)
 	if err != nil {
-		klog.Errorf("failed to sync security group for node %s: %v", nodeName, err)
-		errs = append(errs, err)
+		klog.Errorf("could not get service %s by key: %v", svcName, err)
+		return err
 	}
 
-	if len(errs) == 0 {
-		return nil
+	var svc *v1.Service
+	if exists {
+		var ok bool
+		svc, ok = svcObj.(*v1.Service)
+		if !ok {
+			klog.Errorf("could not get service %s from object", svcName)
+			return fmt.Errorf("could not get service %s from object", svcName)
+		}
+	} else {
+		svc, err = deletedService(svcName)
+		if err != nil {
+			return err
+		}
 	}
 
-	return fmt.Errorf("got several error")
+	return newSyncError(svcName, c.runSyncers(svc, exists))
+}
+
+// deletedService returns a service holding only its namespace and name, for features to clean up after it.
+func deletedService(svcName string) (*v1.Service, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(svcName)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+	}, nil
 }
 
 func (c *SvcController) processNextItem() bool {
@@ -86,6 +118,9 @@ func (c *SvcController) processNextItem() bool {
 	}
 	defer c.queue.Done(key)
 
+	c.status.startProcessing(key)
+	defer c.status.doneProcessing()
+
 	err := c.syncNeeded(key.(string))
 	c.handleErr(err, key)
 	return true
@@ -97,7 +132,7 @@ func (c *SvcController) handleErr(err error, key interface{}) {
 		return
 	}
 
-	if c.queue.NumRequeues(key) < c.numberRetries {
+	if c.queue.NumRequeues(key) < c.getConfig().NumberRetries {
 		c.queue.AddRateLimited(key)
 		return
 	}
@@ -119,8 +154,14 @@ func (c *SvcController) Run(stopCh chan struct{}) {
 		runtime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
 		return
 	}
-
-	go wait.Until(c.runWorker, time.Second, stopCh)
+	c.status.setSynced()
+
+	// the goroutines are part of Wg, for a sync in progress to finish before the controller is considered stopped
+	c.Wg.Add(1)
+	go func() {
+		defer c.Wg.Done()
+		wait.Until(c.runWorker, time.Second, stopCh)
+	}()
 
 	<-stopCh
 }
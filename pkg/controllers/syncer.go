@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/metrics"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+)
+
+// Feature is the part shared by every syncer, whatever the kind of object it handles.
+type Feature interface {
+	// Name returns the name of the feature, used in logs and errors.
+	Name() string
+	// Enabled returns whether the feature is configured and should run.
+	Enabled() bool
+}
+
+// NodeSyncer is a feature reconciled by the NodeController.
+type NodeSyncer interface {
+	Feature
+	// SyncNode reconciles the feature for an existing node.
+	SyncNode(node *v1.Node) error
+	// CleanupNode removes what the feature created for a node. The node may
+	// only have its name set if it was already removed from the cluster, otherwise
+	// it is being deleted and its annotations hold the state recorded by SyncNode.
+	CleanupNode(node *v1.Node) error
+}
+
+// SvcSyncer is a feature reconciled by the SvcController.
+type SvcSyncer interface {
+	Feature
+	// SyncService reconciles the feature for an existing service.
+	SyncService(svc *v1.Service) error
+	// CleanupService removes what the feature created for a service. The service may
+	// only have its namespace and name set if it was already removed from the cluster.
+	CleanupService(svc *v1.Service) error
+}
+
+// SyncResult is the outcome of a single feature for a given key.
+type SyncResult struct {
+	Feature string
+	Err     error
+}
+
+// SyncError aggregates the failed features of a sync.
+type SyncError struct {
+	Key     string
+	Results []SyncResult
+}
+
+func (e *SyncError) Error() string {
+	msgs := make([]string, 0, len(e.Results))
+	for _, res := range e.Results {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", res.Feature, res.Err))
+	}
+	return fmt.Sprintf("failed to sync %s: %s", e.Key, strings.Join(msgs, "; "))
+}
+
+// newSyncError returns a *SyncError holding the failed results, or nil if every feature succeeded.
+func newSyncError(key string, results []SyncResult) error {
+	var failed []SyncResult
+	for _, res := range results {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &SyncError{Key: key, Results: failed}
+}
+
+// nodeSyncerFuncs is an adaptor to let the builtin features be plain NodeController methods.
+type nodeSyncerFuncs struct {
+	name    string
+	enabled func() bool
+	sync    func(node *v1.Node) error
+	cleanup func(node *v1.Node) error
+}
+
+func (f *nodeSyncerFuncs) Name() string {
+	return f.name
+}
+
+func (f *nodeSyncerFuncs) Enabled() bool {
+	return f.enabled()
+}
+
+func (f *nodeSyncerFuncs) SyncNode(node *v1.Node) error {
+	return f.sync(node)
+}
+
+func (f *nodeSyncerFuncs) CleanupNode(node *v1.Node) error {
+	if f.cleanup == nil {
+		return nil
+	}
+	return f.cleanup(node)
+}
+
+// svcSyncerFuncs is an adaptor to let the builtin features be plain SvcController methods.
+type svcSyncerFuncs struct {
+	name    string
+	enabled func() bool
+	sync    func(svc *v1.Service) error
+	cleanup func(svc *v1.Service) error
+}
+
+func (f *svcSyncerFuncs) Name() string {
+	return f.name
+}
+
+func (f *svcSyncerFuncs) Enabled() bool {
+	return f.enabled()
+}
+
+func (f *svcSyncerFuncs) SyncService(svc *v1.Service) error {
+	return f.sync(svc)
+}
+
+func (f *svcSyncerFuncs) CleanupService(svc *v1.Service) error {
+	if f.cleanup == nil {
+		return nil
+	}
+	return f.cleanup(svc)
+}
+
+// AddSyncer registers a new feature on the NodeController. It must be called before Run.
+func (c *NodeController) AddSyncer(s NodeSyncer) {
+	c.syncers = append(c.syncers, s)
+}
+
+// AddSyncer registers a new feature on the SvcController. It must be called before Run.
+func (c *SvcController) AddSyncer(s SvcSyncer) {
+	c.syncers = append(c.syncers, s)
+}
+
+// runSyncers syncs the enabled features selecting the node, or cleans them up if sync is false.
+// A feature not selecting the node anymore is cleaned up once, and recorded as excluded on the node.
+func (c *NodeController) runSyncers(node *v1.Node, sync bool) []SyncResult {
+	var results []SyncResult
+
+	wasExcluded := splitAnnotation(node.Annotations[AnnotationExcludedFeatures])
+	excluded := []string{}
+
+	for _, s := range c.syncers {
+		if !s.Enabled() {
+			continue
+		}
+
+		selected := !sync || c.selectsNode(s.Name(), node)
+		if !selected && stringInSlice(s.Name(), wasExcluded) {
+			excluded = append(excluded, s.Name())
+			continue
+		}
+
+		var err error
+		start := time.Now()
+		if sync && selected {
+			err = s.SyncNode(node)
+		} else {
+			if sync {
+				klog.Infof("node %s is not selected by %s, cleaning up", node.Name, s.Name())
+			}
+			err = s.CleanupNode(node)
+		}
+		// nothing is cleaned up in dry-run mode, so the node is not recorded as excluded and is cleaned up again
+		if !selected && err == nil && !c.scwClient.DryRun {
+			err = c.clearFeatureState(node, s.Name())
+			if err == nil {
+				excluded = append(excluded, s.Name())
+			}
+		}
+		metrics.ObserveReconcile("node", s.Name(), start, err)
+		if err != nil {
+			klog.Errorf("failed to sync %s for node %s: %v", s.Name(), node.Name, err)
+		}
+		results = append(results, SyncResult{Feature: s.Name(), Err: err})
+	}
+
+	if sync {
+		err := c.setNodeAnnotations(node, map[string]string{
+			AnnotationExcludedFeatures: joinAnnotation(excluded),
+		})
+		if err != nil {
+			results = append(results, SyncResult{Feature: "node-selectors", Err: err})
+		}
+	}
+
+	return results
+}
+
+func (c *SvcController) runSyncers(svc *v1.Service, exists bool) []SyncResult {
+	var results []SyncResult
+
+	for _, s := range c.syncers {
+		if !s.Enabled() {
+			continue
+		}
+
+		var err error
+		start := time.Now()
+		if exists {
+			err = s.SyncService(svc)
+		} else {
+			err = s.CleanupService(svc)
+		}
+		metrics.ObserveReconcile("service", s.Name(), start, err)
+		if err != nil {
+			klog.Errorf("failed to sync %s for service %s/%s: %v", s.Name(), svc.Namespace, svc.Name, err)
+		}
+		results = append(results, SyncResult{Feature: s.Name(), Err: err})
+	}
+
+	return results
+}
+
+// deletedNode returns a node holding only its name, for features to clean up after it.
+func deletedNode(nodeName string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: nodeName,
+		},
+	}
+}
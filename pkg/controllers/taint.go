@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	klog "k8s.io/klog/v2"
+)
+
+const (
+	// TaintReservedIPPending keeps the pods off a node until its reserved IP is attached
+	TaintReservedIPPending = "coffee.scaleway.com/reserved-ip-pending"
+)
+
+// taintsPatch is a merge patch of the taints, failing if the node changed in between
+type taintsPatch struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Spec struct {
+		Taints []v1.Taint `json:"taints"`
+	} `json:"spec"`
+}
+
+func hasTaint(node *v1.Node, key string) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// setReservedIPPendingTaint adds the NoSchedule reserved IP pending taint to the node, or removes it whatever its effect.
+// The node is read again, as the one given may be outdated by the patches of the sync, like the taint
+// added before attaching the IP.
+func (c *NodeController) setReservedIPPendingTaint(node *v1.Node, pending bool) error {
+	nodes := c.clientset.CoreV1().Nodes()
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := nodes.Get(context.Background(), node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if hasTaint(current, TaintReservedIPPending) == pending {
+			return nil
+		}
+
+		patch := taintsPatch{}
+		patch.Metadata.ResourceVersion = current.ResourceVersion
+		patch.Spec.Taints = []v1.Taint{}
+		for _, taint := range current.Spec.Taints {
+			if taint.Key != TaintReservedIPPending {
+				patch.Spec.Taints = append(patch.Spec.Taints, taint)
+			}
+		}
+		if pending {
+			patch.Spec.Taints = append(patch.Spec.Taints, v1.Taint{
+				Key:    TaintReservedIPPending,
+				Effect: v1.TaintEffectNoSchedule,
+			})
+		}
+
+		data, err := json.Marshal(patch)
+		if err != nil {
+			return err
+		}
+		_, err = nodes.Patch(context.Background(), node.Name, types.MergePatchType, data, metav1.PatchOptions{})
+		return err
+	})
+	if err != nil {
+		klog.Errorf("could not update the reserved IP pending taint of node %s: %v", node.Name, err)
+		return err
+	}
+
+	if pending {
+		klog.Infof("tainted node %s until its reserved IP is attached", node.Name)
+	} else {
+		klog.Infof("removed reserved IP pending taint of node %s", node.Name)
+	}
+	return nil
+}
@@ -2,15 +2,25 @@ package controllers
 
 import (
 	"sync"
+	"text/template"
+	"time"
 
-	"github.com/scaleway/scaleway-sdk-go/scw"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/config"
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 )
 
 const (
-	defaultNumberRetries = 30
+	FeatureReservedIP    = "reserved-ip"
+	FeatureReverseIP     = "reverse-ip"
+	FeatureDatabaseACLs  = "database-acls"
+	FeatureRedisACLs     = "redis-acls"
+	FeatureSecurityGroup = "security-group"
+	FeatureNodeDNS       = "node-dns"
 )
 
 type NodeController struct {
@@ -21,17 +31,31 @@ type NodeController struct {
 	queue     workqueue.RateLimitingInterface
 	informer  cache.Controller
 
-	scwClient *scw.Client
+	scwClient *scaleway.Client
+	recorder  record.EventRecorder
 
-	reverseIPDomain  string
-	scwZoneFound     bool
-	scwZone          string
-	databaseIDs      []string
-	redisIDs         []string
-	reservedIPs      []string
-	securityGroupIDs []string
+	configMu      sync.RWMutex
+	config        *config.Config
+	scwZoneFound  bool
+	scwZone       string
+	reverseName   *template.Template
+	nodeSelectors map[string]labels.Selector
+	ipPools       []reservedIPPool
+	// nodeDNSZone and nodeDNSPrivateZone are the Scaleway DNS zones holding the node DNS domains, empty if not found
+	nodeDNSZone        string
+	nodeDNSPrivateZone string
 
-	numberRetries int
+	// reservedIPMu is held while picking and attaching a reserved IP, or releasing one
+	reservedIPMu sync.Mutex
+
+	syncers []NodeSyncer
+
+	state          *configMapStore
+	ipAssignments  *configMapStore
+	gcMissingSince map[string]time.Time
+	dnsPending     map[string]dnsPropagation
+
+	status workerStatus
 }
 
 type SvcController struct {
@@ -41,9 +65,13 @@ type SvcController struct {
 	queue    workqueue.RateLimitingInterface
 	informer cache.Controller
 
-	scwClient *scw.Client
+	scwClient *scaleway.Client
+	recorder  record.EventRecorder
+
+	configMu sync.RWMutex
+	config   *config.Config
 
-	securityGroupIDs []string
+	syncers []SvcSyncer
 
-	numberRetries int
+	status workerStatus
 }
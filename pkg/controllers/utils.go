@@ -1,43 +1,227 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"sort"
 	"strings"
 
 	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
 	"github.com/scaleway/scaleway-sdk-go/scw"
 	"k8s.io/api/core/v1"
+	klog "k8s.io/klog/v2"
 )
 
-func (c *NodeController) getInstanceFromNodeName(nodeName string) (*instance.Server, error) {
-	instanceAPI := instance.NewAPI(c.scwClient)
+const (
+	providerIDPrefix = "scaleway://instance/"
+)
+
+// getInstanceFromNode returns the server of the node, from its provider ID if set, and from its name otherwise
+func (c *NodeController) getInstanceFromNode(node *v1.Node) (*instance.Server, error) {
+	if node.Spec.ProviderID == "" {
+		return c.getInstanceFromNodeName(node.Name, getNodeZone(node))
+	}
+
+	zone, id, err := parseProviderID(node.Spec.ProviderID)
+	if err != nil {
+		klog.Warningf("could not parse provider ID of node %s, looking it up by name: %v", node.Name, err)
+		return c.getInstanceFromNodeName(node.Name, getNodeZone(node))
+	}
+
+	resp, err := c.scwClient.Instance.GetServer(&instance.GetServerRequest{
+		Zone:     zone,
+		ServerID: id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Server, nil
+}
+
+// getInstanceFromNodeName returns the server named after the node in the given zone, the default one if empty
+func (c *NodeController) getInstanceFromNodeName(nodeName string, zone scw.Zone) (*instance.Server, error) {
+	instanceAPI := c.scwClient.Instance
 
 	instanceResp, err := instanceAPI.ListServers(&instance.ListServersRequest{
+		Zone: zone,
 		Name: scw.StringPtr(nodeName),
-	})
+	}, scw.WithAllPages())
 	if err != nil {
 		return nil, err
 	}
-	if len(instanceResp.Servers) != 1 {
-		return nil, fmt.Errorf("got %d servers instead of 1", len(instanceResp.Servers))
+
+	// the name filter matches substrings
+	servers := []*instance.Server{}
+	for _, server := range instanceResp.Servers {
+		if server.Name == nodeName {
+			servers = append(servers, server)
+		}
+	}
+	if len(servers) != 1 {
+		return nil, fmt.Errorf("got %d servers instead of 1", len(servers))
 	}
-	return instanceResp.Servers[0], nil
+	return servers[0], nil
 }
 
-func (c *NodeController) getFreeIP() (*instance.IP, error) {
-	instanceAPI := instance.NewAPI(c.scwClient)
+// parseProviderID returns the zone and the ID of a scaleway://instance/<zone>/<id> provider ID
+func parseProviderID(providerID string) (scw.Zone, string, error) {
+	if !strings.HasPrefix(providerID, providerIDPrefix) {
+		return "", "", fmt.Errorf("provider ID %s does not start with %s", providerID, providerIDPrefix)
+	}
+
+	split := strings.Split(strings.TrimPrefix(providerID, providerIDPrefix), "/")
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return "", "", fmt.Errorf("provider ID %s is not %s<zone>/<id>", providerID, providerIDPrefix)
+	}
 
-	ipsList, err := instanceAPI.ListIPs(&instance.ListIPsRequest{}, scw.WithAllPages())
+	zone, err := scw.ParseZone(split[0])
 	if err != nil {
-		return nil, err
+		return "", "", fmt.Errorf("provider ID %s has an invalid zone: %v", providerID, err)
+	}
+	return zone, split[1], nil
+}
+
+// getNodeZone returns the zone of the node, from its provider ID or its topology label, or an empty zone if unknown
+func getNodeZone(node *v1.Node) scw.Zone {
+	if zone, _, err := parseProviderID(node.Spec.ProviderID); err == nil {
+		return zone
 	}
+	if zone, err := scw.ParseZone(node.Labels[v1.LabelTopologyZone]); err == nil {
+		return zone
+	}
+	return ""
+}
+
+// poolUsage is the state of a reserved IP pool in a zone
+type poolUsage struct {
+	free     int
+	attached int
+	// otherZones are the addresses of the pool that are not in the zone: those with another zone,
+	// and the ones without zone not found in it
+	otherZones []string
+}
 
+// size returns the number of IPs of the pool in the zone
+func (u *poolUsage) size() int {
+	return u.free + u.attached
+}
+
+// getFreeIP returns a free IP of the reserved pool in the given zone along with the usage of the pool,
+// the pool being listed again on every call to find the newly tagged IPs. The IPs of avoid are only
+// returned when no other IP is free.
+func (c *NodeController) getFreeIP(zone scw.Zone, reservedPool *reservedIPPool, avoid map[string]bool) (*instance.IP, *poolUsage, error) {
+	instanceAPI := c.scwClient.Instance
+
+	ipsList, err := instanceAPI.ListIPs(&instance.ListIPsRequest{
+		Zone: zone,
+	}, scw.WithAllPages())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool := make(map[string]bool)
+	otherZones := []string{}
+	for _, reservedIP := range reservedPool.ips {
+		address, ipZone, _ := getZonalID(reservedIP)
+		if ipZone != "" && ipZone != zone.String() {
+			otherZones = append(otherZones, address)
+			continue
+		}
+		pool[address] = false
+	}
+
+	var freeIP, avoidedIP *instance.IP
+	free, attached := 0, 0
 	for _, ip := range ipsList.IPs {
-		if ip.Server == nil && stringInSlice(ip.Address.String(), c.reservedIPs) {
-			return ip, nil
+		if !reservedPool.contains(ip) {
+			continue
+		}
+		if _, ok := pool[ip.Address.String()]; ok {
+			pool[ip.Address.String()] = true
+		}
+		if ip.Server != nil {
+			attached++
+			continue
+		}
+		free++
+		if avoid[ip.ID] {
+			if avoidedIP == nil {
+				avoidedIP = ip
+			}
+		} else if freeIP == nil {
+			freeIP = ip
 		}
 	}
-	return nil, nil
+	if freeIP == nil {
+		freeIP = avoidedIP
+	}
+
+	for address, found := range pool {
+		if !found {
+			otherZones = append(otherZones, address)
+		}
+	}
+	sort.Strings(otherZones)
+
+	return freeIP, &poolUsage{
+		free:       free,
+		attached:   attached,
+		otherZones: otherZones,
+	}, nil
+}
+
+// getNodeAddresses returns the first external and internal IPv4 of the node, if any
+func getNodeAddresses(node *v1.Node) (net.IP, net.IP) {
+	var externalIP, internalIP net.IP
+	for _, addr := range node.Status.Addresses {
+		ip := net.ParseIP(addr.Address).To4()
+		switch {
+		case ip == nil:
+		case addr.Type == v1.NodeExternalIP && externalIP == nil:
+			externalIP = ip
+		case addr.Type == v1.NodeInternalIP && internalIP == nil:
+			internalIP = ip
+		}
+	}
+	return externalIP, internalIP
+}
+
+// getNodeIPv6 returns the first external or internal IPv6 of the node, if any
+func getNodeIPv6(node *v1.Node) net.IP {
+	for _, addr := range node.Status.Addresses {
+		if addr.Type != v1.NodeExternalIP && addr.Type != v1.NodeInternalIP {
+			continue
+		}
+		if ip := net.ParseIP(addr.Address); ip != nil && ip.To4() == nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+// appendIPs appends the set IPs to ips
+func appendIPs(ips []net.IP, more ...net.IP) []net.IP {
+	for _, ip := range more {
+		if ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// hostIPNet returns the network of the single IP, a /32 for an IPv4 and a /128 for an IPv6
+func hostIPNet(ip net.IP) net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// isNotFound returns whether the Scaleway API answered that the resource does not exist
+func isNotFound(err error) bool {
+	notFound := &scw.ResourceNotFoundError{}
+	return errors.As(err, &notFound)
 }
 
 func stringInSlice(s string, slice []string) bool {
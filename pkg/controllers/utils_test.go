@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway/fake"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestParseProviderID(t *testing.T) {
+	for _, tt := range []struct {
+		providerID string
+		zone       scw.Zone
+		id         string
+		valid      bool
+	}{
+		{providerID: "scaleway://instance/fr-par-1/11111111-1111-4111-8111-111111111111", zone: scw.ZoneFrPar1, id: "11111111-1111-4111-8111-111111111111", valid: true},
+		{providerID: "scaleway://instance/nl-ams-1/server", zone: scw.ZoneNlAms1, id: "server", valid: true},
+		{providerID: ""},
+		{providerID: "scaleway://"},
+		{providerID: "scaleway://instance/"},
+		{providerID: "aws://instance/fr-par-1/server"},
+		{providerID: "scaleway://instance/server"},
+		{providerID: "scaleway://instance//server"},
+		{providerID: "scaleway://instance/fr-par-1/"},
+		{providerID: "scaleway://instance/fr-par-1/server/extra"},
+		{providerID: "scaleway://instance/not-a-zone/server"},
+	} {
+		t.Run(tt.providerID, func(t *testing.T) {
+			zone, id, err := parseProviderID(tt.providerID)
+			if !tt.valid {
+				if err == nil {
+					t.Errorf("expected provider ID %q to be rejected, got %s %s", tt.providerID, zone, id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not parse provider ID %q: %v", tt.providerID, err)
+			}
+			if zone != tt.zone || id != tt.id {
+				t.Errorf("expected %s %s, got %s %s", tt.zone, tt.id, zone, id)
+			}
+		})
+	}
+}
+
+func TestGetInstanceFromNode(t *testing.T) {
+	b := fake.NewBackend()
+	first := b.AddServer("node-1", "", "", "10.0.0.1")
+	b.AddServer("node-10", "", "", "10.0.0.10")
+	b.AddServer("node-dup", "", "", "10.0.0.2")
+	b.AddServer("node-dup", "", "", "10.0.0.3")
+	other := b.AddServer("node-2", scw.ZoneFrPar2, "", "10.0.1.1")
+
+	c, _ := newTestNodeController(t, b.Client(), newTestConfig())
+
+	for _, tt := range []struct {
+		name       string
+		nodeName   string
+		providerID string
+		zoneLabel  string
+		// wanted is the ID of the server found, empty if none is
+		wanted string
+	}{
+		{name: "provider ID", nodeName: "renamed", providerID: "scaleway://instance/fr-par-2/" + other.ID, wanted: other.ID},
+		{name: "provider ID of another zone", nodeName: "node-2", providerID: "scaleway://instance/fr-par-1/" + other.ID},
+		{name: "invalid provider ID", nodeName: "node-1", providerID: "scaleway://instance/" + first.ID, wanted: first.ID},
+		// the name filter of the API also returns node-10
+		{name: "exact name", nodeName: "node-1", wanted: first.ID},
+		{name: "no exact name", nodeName: "node"},
+		{name: "several exact names", nodeName: "node-dup"},
+		{name: "name in the default zone", nodeName: "node-2"},
+		{name: "name in the zone label", nodeName: "node-2", zoneLabel: "fr-par-2", wanted: other.ID},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			node := newTestNode(tt.nodeName)
+			node.Spec.ProviderID = tt.providerID
+			if tt.zoneLabel != "" {
+				node.Labels = map[string]string{v1.LabelTopologyZone: tt.zoneLabel}
+			}
+
+			server, err := c.getInstanceFromNode(node)
+			if tt.wanted == "" {
+				if err == nil {
+					t.Errorf("expected no server, got %s", server.ID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not get server: %v", err)
+			}
+			if server.ID != tt.wanted {
+				t.Errorf("expected server %s, got %s", tt.wanted, server.ID)
+			}
+		})
+	}
+}
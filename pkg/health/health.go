@@ -0,0 +1,63 @@
+// Package health serves the liveness and readiness endpoints.
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Checker returns an error when the checked component is not healthy
+type Checker func() error
+
+// Handler runs every registered check on each request, and fails if any of them fails
+type Handler struct {
+	mu     sync.RWMutex
+	checks map[string]Checker
+}
+
+// NewHandler returns a Handler without any check
+func NewHandler() *Handler {
+	return &Handler{
+		checks: make(map[string]Checker),
+	}
+}
+
+// AddCheck registers a named check, replacing any check with the same name
+func (h *Handler) AddCheck(name string, check Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks[name] = check
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	h.mu.RUnlock()
+	sort.Strings(names)
+
+	var failed []string
+	for _, name := range names {
+		h.mu.RLock()
+		check := h.checks[name]
+		h.mu.RUnlock()
+
+		if err := check(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(failed) != 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(failed, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,124 @@
+// Package metrics holds the Prometheus metrics of the controllers.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "coffee"
+
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+var (
+	// Registry holds every metric of the controllers, along with the process and go ones
+	Registry = prometheus.NewRegistry()
+
+	ReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_total",
+		Help:      "Number of reconciliations per controller, feature and result.",
+	}, []string{"controller", "feature", "result"})
+
+	ReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of the reconciliations per controller, feature and result.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"controller", "feature", "result"})
+
+	ScalewayRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scaleway_request_duration_seconds",
+		Help:      "Duration of the Scaleway API calls per product and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"product", "method"})
+
+	ScalewayRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scaleway_request_errors_total",
+		Help:      "Number of failed Scaleway API calls per product and method.",
+	}, []string{"product", "method"})
+
+	ReservedIPs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reserved_ips",
+		Help:      "Number of reserved IPs per pool, zone and state (free or attached).",
+	}, []string{"pool", "zone", "state"})
+
+	ReservedIPsProvisionedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reserved_ips_provisioned_total",
+		Help:      "Number of reserved IPs booked per pool.",
+	}, []string{"pool"})
+
+	ReservedIPsReleasedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reserved_ips_released_total",
+		Help:      "Number of booked reserved IPs released once unused per pool.",
+	}, []string{"pool"})
+
+	GarbageCollectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "garbage_collected_total",
+		Help:      "Number of resources of gone nodes removed by the garbage collection per resource.",
+	}, []string{"resource"})
+
+	PlannedActionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "planned_actions_total",
+		Help:      "Number of mutating Scaleway API calls not sent in dry-run mode per product and method.",
+	}, []string{"product", "method"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+		ReconcileTotal,
+		ReconcileDuration,
+		ScalewayRequestDuration,
+		ScalewayRequestErrors,
+		ReservedIPs,
+		ReservedIPsProvisionedTotal,
+		ReservedIPsReleasedTotal,
+		GarbageCollectedTotal,
+		PlannedActionsTotal,
+	)
+}
+
+// Handler returns the http handler serving the metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveReconcile records the duration and result of a feature reconciliation
+func ObserveReconcile(controller string, feature string, start time.Time, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	ReconcileTotal.WithLabelValues(controller, feature, result).Inc()
+	ReconcileDuration.WithLabelValues(controller, feature, result).Observe(time.Since(start).Seconds())
+}
+
+// ObserveScalewayRequest records the duration and the failure of a Scaleway API call.
+// It is meant to be deferred, err pointing to the named error returned by the call.
+func ObserveScalewayRequest(product string, method string, start time.Time, err *error) {
+	ScalewayRequestDuration.WithLabelValues(product, method).Observe(time.Since(start).Seconds())
+	if err != nil && *err != nil {
+		ScalewayRequestErrors.WithLabelValues(product, method).Inc()
+	}
+}
+
+// SetReservedIPs records the usage of a reserved IPs pool in a zone
+func SetReservedIPs(pool string, zone string, free int, attached int) {
+	ReservedIPs.WithLabelValues(pool, zone, "free").Set(float64(free))
+	ReservedIPs.WithLabelValues(pool, zone, "attached").Set(float64(attached))
+}
@@ -0,0 +1,14 @@
+package scaleway
+
+import (
+	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+// CheckCredentials makes a cheap authenticated call, to make sure the API is reachable with the given credentials
+func (c *Client) CheckCredentials() error {
+	_, err := c.Instance.ListServers(&instance.ListServersRequest{
+		PerPage: scw.Uint32Ptr(1),
+	})
+	return err
+}
@@ -0,0 +1,188 @@
+package scaleway
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/metrics"
+	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
+	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	rdb "github.com/scaleway/scaleway-sdk-go/api/rdb/v1"
+	redis "github.com/scaleway/scaleway-sdk-go/api/redis/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+	klog "k8s.io/klog/v2"
+)
+
+// PlannedAction is a mutating call that was not sent to Scaleway
+type PlannedAction struct {
+	Time    time.Time              `json:"time"`
+	Product string                 `json:"product"`
+	Method  string                 `json:"method"`
+	Request map[string]interface{} `json:"request"`
+}
+
+// Plan keeps the last planned actions
+type Plan struct {
+	mu      sync.Mutex
+	max     int
+	actions []PlannedAction
+}
+
+// NewPlan returns a Plan keeping at most max actions
+func NewPlan(max int) *Plan {
+	return &Plan{
+		max: max,
+	}
+}
+
+// Record logs and keeps a planned action
+func (p *Plan) Record(product string, method string, req interface{}) {
+	action := PlannedAction{
+		Time:    time.Now(),
+		Product: product,
+		Method:  method,
+		Request: requestFields(req),
+	}
+
+	data, err := json.Marshal(action.Request)
+	if err != nil {
+		klog.Errorf("could not encode planned action %s.%s: %v", product, method, err)
+	}
+	klog.Infof("dry-run: would call %s.%s %s", product, method, data)
+	metrics.PlannedActionsTotal.WithLabelValues(product, method).Inc()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.actions = append(p.actions, action)
+	if len(p.actions) > p.max {
+		p.actions = p.actions[len(p.actions)-p.max:]
+	}
+}
+
+// Actions returns the kept actions, oldest first
+func (p *Plan) Actions() []PlannedAction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]PlannedAction{}, p.actions...)
+}
+
+// ServeHTTP returns the kept actions as JSON
+func (p *Plan) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(p.Actions())
+	if err != nil {
+		klog.Errorf("could not write planned actions: %v", err)
+	}
+}
+
+// requestFields returns the set fields of a request by name, as the SDK requests do not encode
+// the fields sent in the path, like the zone or the resource ID
+func requestFields(req interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+
+	v := reflect.Indirect(reflect.ValueOf(req))
+	if v.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" || v.Field(i).IsZero() {
+			continue
+		}
+		fields[field.Name] = v.Field(i).Interface()
+	}
+	return fields
+}
+
+// WithDryRun returns a Client recording the mutating calls in plan instead of sending them,
+// the read only calls still going through c
+func WithDryRun(c *Client, plan *Plan) *Client {
+	return &Client{
+		Instance: &instanceDryRun{c.Instance, plan},
+		RDB:      &rdbDryRun{c.RDB, plan},
+		Redis:    &redisDryRun{c.Redis, plan},
+		Domain:   &domainDryRun{c.Domain, plan},
+		DryRun:   true,
+	}
+}
+
+type instanceDryRun struct {
+	InstanceAPI
+	plan *Plan
+}
+
+func (a *instanceDryRun) UpdateIP(req *instance.UpdateIPRequest, opts ...scw.RequestOption) (*instance.UpdateIPResponse, error) {
+	a.plan.Record("instance", "UpdateIP", req)
+	return &instance.UpdateIPResponse{}, nil
+}
+
+// CreateIP returns an IP without ID nor address, as none is booked
+func (a *instanceDryRun) CreateIP(req *instance.CreateIPRequest, opts ...scw.RequestOption) (*instance.CreateIPResponse, error) {
+	a.plan.Record("instance", "CreateIP", req)
+	return &instance.CreateIPResponse{
+		IP: &instance.IP{
+			Zone: req.Zone,
+			Tags: req.Tags,
+		},
+	}, nil
+}
+
+func (a *instanceDryRun) DeleteIP(req *instance.DeleteIPRequest, opts ...scw.RequestOption) error {
+	a.plan.Record("instance", "DeleteIP", req)
+	return nil
+}
+
+func (a *instanceDryRun) CreateSecurityGroupRule(req *instance.CreateSecurityGroupRuleRequest, opts ...scw.RequestOption) (*instance.CreateSecurityGroupRuleResponse, error) {
+	a.plan.Record("instance", "CreateSecurityGroupRule", req)
+	return &instance.CreateSecurityGroupRuleResponse{}, nil
+}
+
+func (a *instanceDryRun) DeleteSecurityGroupRule(req *instance.DeleteSecurityGroupRuleRequest, opts ...scw.RequestOption) error {
+	a.plan.Record("instance", "DeleteSecurityGroupRule", req)
+	return nil
+}
+
+type rdbDryRun struct {
+	RDBAPI
+	plan *Plan
+}
+
+func (a *rdbDryRun) AddInstanceACLRules(req *rdb.AddInstanceACLRulesRequest, opts ...scw.RequestOption) (*rdb.AddInstanceACLRulesResponse, error) {
+	a.plan.Record("rdb", "AddInstanceACLRules", req)
+	return &rdb.AddInstanceACLRulesResponse{}, nil
+}
+
+func (a *rdbDryRun) DeleteInstanceACLRules(req *rdb.DeleteInstanceACLRulesRequest, opts ...scw.RequestOption) (*rdb.DeleteInstanceACLRulesResponse, error) {
+	a.plan.Record("rdb", "DeleteInstanceACLRules", req)
+	return &rdb.DeleteInstanceACLRulesResponse{}, nil
+}
+
+type redisDryRun struct {
+	RedisAPI
+	plan *Plan
+}
+
+func (a *redisDryRun) AddACLRules(req *redis.AddACLRulesRequest, opts ...scw.RequestOption) (*redis.AddACLRulesResponse, error) {
+	a.plan.Record("redis", "AddACLRules", req)
+	return &redis.AddACLRulesResponse{}, nil
+}
+
+func (a *redisDryRun) DeleteACLRule(req *redis.DeleteACLRuleRequest, opts ...scw.RequestOption) (*redis.Cluster, error) {
+	a.plan.Record("redis", "DeleteACLRule", req)
+	return &redis.Cluster{}, nil
+}
+
+type domainDryRun struct {
+	DomainAPI
+	plan *Plan
+}
+
+func (a *domainDryRun) UpdateDNSZoneRecords(req *dns.UpdateDNSZoneRecordsRequest, opts ...scw.RequestOption) (*dns.UpdateDNSZoneRecordsResponse, error) {
+	a.plan.Record("domain", "UpdateDNSZoneRecords", req)
+	return &dns.UpdateDNSZoneRecordsResponse{}, nil
+}
@@ -0,0 +1,153 @@
+package fake
+
+import (
+	"strings"
+
+	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+type domainAPI struct {
+	b *Backend
+}
+
+func (a *domainAPI) ListDNSZones(req *dns.ListDNSZonesRequest, opts ...scw.RequestOption) (*dns.ListDNSZonesResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	resp := &dns.ListDNSZonesResponse{}
+	for _, zone := range a.b.dnsZones {
+		if req.Domain != "" && zone.Domain != req.Domain {
+			continue
+		}
+		if req.DNSZone != "" && zoneName(zone) != req.DNSZone {
+			continue
+		}
+		zoneCopy := *zone
+		resp.DNSZones = append(resp.DNSZones, &zoneCopy)
+	}
+	resp.TotalCount = uint32(len(resp.DNSZones))
+
+	return resp, nil
+}
+
+func (a *domainAPI) ListDNSZoneRecords(req *dns.ListDNSZoneRecordsRequest, opts ...scw.RequestOption) (*dns.ListDNSZoneRecordsResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	if !a.b.hasDNSZone(req.DNSZone) {
+		return nil, notFound("dns_zone", req.DNSZone)
+	}
+
+	records := []*dns.Record{}
+	for _, record := range a.b.dnsRecords[req.DNSZone] {
+		if req.Name != "" && record.Name != req.Name {
+			continue
+		}
+		if req.Type != "" && req.Type != dns.RecordTypeUnknown && record.Type != req.Type {
+			continue
+		}
+		if req.ID != nil && record.ID != *req.ID {
+			continue
+		}
+		records = append(records, copyRecord(record))
+	}
+
+	resp := &dns.ListDNSZoneRecordsResponse{
+		TotalCount: uint32(len(records)),
+	}
+
+	page, pageSize := 1, len(records)
+	if req.Page != nil && *req.Page > 0 {
+		page = int(*req.Page)
+	}
+	if req.PageSize != nil && *req.PageSize > 0 {
+		pageSize = int(*req.PageSize)
+	}
+	start := (page - 1) * pageSize
+	if start < len(records) {
+		end := start + pageSize
+		if end > len(records) {
+			end = len(records)
+		}
+		resp.Records = records[start:end]
+	}
+
+	return resp, nil
+}
+
+func (a *domainAPI) UpdateDNSZoneRecords(req *dns.UpdateDNSZoneRecordsRequest, opts ...scw.RequestOption) (*dns.UpdateDNSZoneRecordsResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	if !a.b.hasDNSZone(req.DNSZone) {
+		return nil, notFound("dns_zone", req.DNSZone)
+	}
+
+	resp := &dns.UpdateDNSZoneRecordsResponse{}
+	records := a.b.dnsRecords[req.DNSZone]
+
+	for _, change := range req.Changes {
+		switch {
+		case change.Add != nil:
+			for _, r := range change.Add.Records {
+				record := copyRecord(r)
+				record.ID = a.b.newID()
+				records = append(records, record)
+				resp.Records = append(resp.Records, record)
+			}
+		case change.Set != nil:
+			records = deleteRecords(records, change.Set.ID, change.Set.IDFields)
+			for _, r := range change.Set.Records {
+				record := copyRecord(r)
+				record.ID = a.b.newID()
+				records = append(records, record)
+				resp.Records = append(resp.Records, record)
+			}
+		case change.Delete != nil:
+			records = deleteRecords(records, change.Delete.ID, change.Delete.IDFields)
+		case change.Clear != nil:
+			records = nil
+		}
+	}
+
+	a.b.dnsRecords[req.DNSZone] = records
+	return resp, nil
+}
+
+func (b *Backend) hasDNSZone(name string) bool {
+	for _, zone := range b.dnsZones {
+		if zoneName(zone) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func deleteRecords(records []*dns.Record, id *string, idFields *dns.RecordIdentifier) []*dns.Record {
+	kept := []*dns.Record{}
+	for _, record := range records {
+		if id != nil && record.ID == *id {
+			continue
+		}
+		if idFields != nil && record.Name == idFields.Name && record.Type == idFields.Type &&
+			(idFields.Data == nil || record.Data == *idFields.Data) &&
+			(idFields.TTL == nil || record.TTL == *idFields.TTL) {
+			continue
+		}
+		kept = append(kept, record)
+	}
+	return kept
+}
+
+func zoneName(zone *dns.DNSZone) string {
+	return strings.TrimPrefix(zone.Subdomain+"."+zone.Domain, ".")
+}
+
+func copyRecord(record *dns.Record) *dns.Record {
+	r := *record
+	if record.Comment != nil {
+		r.Comment = scw.StringPtr(*record.Comment)
+	}
+	return &r
+}
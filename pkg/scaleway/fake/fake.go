@@ -0,0 +1,282 @@
+// Package fake provides an in-memory implementation of the Scaleway APIs used by the controllers.
+package fake
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
+	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	rdb "github.com/scaleway/scaleway-sdk-go/api/rdb/v1"
+	redis "github.com/scaleway/scaleway-sdk-go/api/redis/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/scaleway"
+)
+
+const (
+	DefaultZone   = scw.ZoneFrPar1
+	DefaultRegion = scw.RegionFrPar
+)
+
+// Backend keeps the state of the fake Scaleway APIs. It is safe for concurrent use.
+type Backend struct {
+	// Zone is used for zonal requests without a zone, like the SCW_DEFAULT_ZONE of a real client
+	Zone scw.Zone
+	// Region is used for regional requests without a region, like the SCW_DEFAULT_REGION of a real client
+	Region scw.Region
+
+	mu     sync.Mutex
+	lastID int
+
+	servers            map[string]*instance.Server
+	ips                map[string]*instance.IP
+	securityGroups     map[string]scw.Zone
+	securityGroupRules map[string][]*instance.SecurityGroupRule
+	databases          map[string]*rdb.Instance
+	databaseACLs       map[string][]*rdb.ACLRule
+	redisClusters      map[string]*redis.Cluster
+	dnsZones           []*dns.DNSZone
+	dnsRecords         map[string][]*dns.Record
+}
+
+// NewBackend returns an empty Backend in the default zone and region
+func NewBackend() *Backend {
+	return &Backend{
+		Zone:               DefaultZone,
+		Region:             DefaultRegion,
+		servers:            make(map[string]*instance.Server),
+		ips:                make(map[string]*instance.IP),
+		securityGroups:     make(map[string]scw.Zone),
+		securityGroupRules: make(map[string][]*instance.SecurityGroupRule),
+		databases:          make(map[string]*rdb.Instance),
+		databaseACLs:       make(map[string][]*rdb.ACLRule),
+		redisClusters:      make(map[string]*redis.Cluster),
+		dnsRecords:         make(map[string][]*dns.Record),
+	}
+}
+
+// Client returns a scaleway.Client backed by b
+func (b *Backend) Client() *scaleway.Client {
+	return &scaleway.Client{
+		Instance: &instanceAPI{b},
+		RDB:      &rdbAPI{b},
+		Redis:    &redisAPI{b},
+		Domain:   &domainAPI{b},
+	}
+}
+
+// AddServer adds a server with a dynamic public IP and a private IP, any of them can be empty
+func (b *Backend) AddServer(name string, zone scw.Zone, publicIP string, privateIP string) *instance.Server {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	server := &instance.Server{
+		ID:    b.newID(),
+		Name:  name,
+		Zone:  b.zone(zone),
+		State: instance.ServerStateRunning,
+	}
+	if publicIP != "" {
+		server.PublicIP = &instance.ServerIP{
+			ID:      b.newID(),
+			Address: net.ParseIP(publicIP),
+			Dynamic: true,
+		}
+		server.DynamicIPRequired = true
+	}
+	if privateIP != "" {
+		server.PrivateIP = scw.StringPtr(privateIP)
+	}
+
+	b.servers[server.ID] = server
+	return server
+}
+
+// SetServerIPv6 sets the IPv6 of a server, removing it if address is empty
+func (b *Backend) SetServerIPv6(id string, address string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	server, ok := b.servers[id]
+	if !ok {
+		return
+	}
+	server.EnableIPv6 = address != ""
+	server.IPv6 = nil
+	if address != "" {
+		server.IPv6 = &instance.ServerIPv6{
+			Address: net.ParseIP(address),
+			Netmask: "64",
+		}
+	}
+}
+
+// DeleteServer removes a server, detaching its flexible IP
+func (b *Backend) DeleteServer(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ip := range b.ips {
+		if ip.Server != nil && ip.Server.ID == id {
+			ip.Server = nil
+		}
+	}
+	delete(b.servers, id)
+}
+
+// AddIP adds an unattached flexible IP
+func (b *Backend) AddIP(address string, zone scw.Zone, tags ...string) *instance.IP {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ip := &instance.IP{
+		ID:      b.newID(),
+		Address: net.ParseIP(address),
+		Zone:    b.zone(zone),
+		Tags:    tags,
+	}
+
+	b.ips[ip.ID] = ip
+	return ip
+}
+
+// AddSecurityGroup adds an empty security group
+func (b *Backend) AddSecurityGroup(id string, zone scw.Zone) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.securityGroups[id] = b.zone(zone)
+}
+
+// AddDatabase adds a database instance without any ACL rule
+func (b *Backend) AddDatabase(id string, region scw.Region) *rdb.Instance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if region == "" {
+		region = b.Region
+	}
+	db := &rdb.Instance{
+		ID:     id,
+		Region: region,
+		Status: rdb.InstanceStatusReady,
+	}
+
+	b.databases[id] = db
+	return db
+}
+
+// AddRedisCluster adds a redis cluster without any ACL rule
+func (b *Backend) AddRedisCluster(id string, zone scw.Zone) *redis.Cluster {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cluster := &redis.Cluster{
+		ID:     id,
+		Zone:   b.zone(zone),
+		Status: redis.ClusterStatusReady,
+	}
+
+	b.redisClusters[id] = cluster
+	return cluster
+}
+
+// AddDNSZone adds an empty DNS zone
+func (b *Backend) AddDNSZone(domain string, subdomain string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.dnsZones = append(b.dnsZones, &dns.DNSZone{
+		Domain:    domain,
+		Subdomain: subdomain,
+		Status:    dns.DNSZoneStatusActive,
+	})
+}
+
+// Server returns a copy of the server, or nil if it does not exist
+func (b *Backend) Server(id string) *instance.Server {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	server, ok := b.servers[id]
+	if !ok {
+		return nil
+	}
+	return copyServer(server)
+}
+
+// IP returns a copy of the flexible IP with the given ID or address, or nil if it does not exist
+func (b *Backend) IP(idOrAddress string) *instance.IP {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ip := b.findIP("", idOrAddress)
+	if ip == nil {
+		return nil
+	}
+	return copyIP(ip)
+}
+
+// SecurityGroupRules returns the rules of the security group
+func (b *Backend) SecurityGroupRules(id string) []*instance.SecurityGroupRule {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]*instance.SecurityGroupRule{}, b.securityGroupRules[id]...)
+}
+
+// DatabaseACLRules returns the ACL rules of the database instance
+func (b *Backend) DatabaseACLRules(id string) []*rdb.ACLRule {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]*rdb.ACLRule{}, b.databaseACLs[id]...)
+}
+
+// RedisACLRules returns the ACL rules of the redis cluster
+func (b *Backend) RedisACLRules(id string) []*redis.ACLRule {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cluster, ok := b.redisClusters[id]
+	if !ok {
+		return nil
+	}
+	return append([]*redis.ACLRule{}, cluster.ACLRules...)
+}
+
+// DNSRecords returns the records of the DNS zone
+func (b *Backend) DNSRecords(zone string) []*dns.Record {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]*dns.Record{}, b.dnsRecords[zone]...)
+}
+
+func (b *Backend) newID() string {
+	b.lastID++
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", b.lastID)
+}
+
+func (b *Backend) zone(zone scw.Zone) scw.Zone {
+	if zone == "" {
+		return b.Zone
+	}
+	return zone
+}
+
+func (b *Backend) region(region scw.Region) scw.Region {
+	if region == "" {
+		return b.Region
+	}
+	return region
+}
+
+func notFound(resource string, id string) error {
+	return &scw.ResourceNotFoundError{
+		Resource:   resource,
+		ResourceID: id,
+	}
+}
@@ -0,0 +1,302 @@
+package fake
+
+import (
+	"net"
+	"strings"
+
+	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+type instanceAPI struct {
+	b *Backend
+}
+
+func (a *instanceAPI) ListServers(req *instance.ListServersRequest, opts ...scw.RequestOption) (*instance.ListServersResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	zone := a.b.zone(req.Zone)
+	resp := &instance.ListServersResponse{}
+
+	for _, server := range a.b.servers {
+		if server.Zone != zone {
+			continue
+		}
+		// like the real API, the name filter is a substring match
+		if req.Name != nil && !strings.Contains(server.Name, *req.Name) {
+			continue
+		}
+		if !hasTags(server.Tags, req.Tags) {
+			continue
+		}
+		resp.Servers = append(resp.Servers, copyServer(server))
+	}
+	resp.TotalCount = uint32(len(resp.Servers))
+
+	return resp, nil
+}
+
+func (a *instanceAPI) GetServer(req *instance.GetServerRequest, opts ...scw.RequestOption) (*instance.GetServerResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	server, ok := a.b.servers[req.ServerID]
+	if !ok || server.Zone != a.b.zone(req.Zone) {
+		return nil, notFound("instance_server", req.ServerID)
+	}
+
+	return &instance.GetServerResponse{Server: copyServer(server)}, nil
+}
+
+func (a *instanceAPI) ListIPs(req *instance.ListIPsRequest, opts ...scw.RequestOption) (*instance.ListIPsResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	zone := a.b.zone(req.Zone)
+	resp := &instance.ListIPsResponse{}
+
+	for _, ip := range a.b.ips {
+		if ip.Zone != zone || !hasTags(ip.Tags, req.Tags) {
+			continue
+		}
+		resp.IPs = append(resp.IPs, copyIP(ip))
+	}
+	resp.TotalCount = uint32(len(resp.IPs))
+
+	return resp, nil
+}
+
+func (a *instanceAPI) GetIP(req *instance.GetIPRequest, opts ...scw.RequestOption) (*instance.GetIPResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	ip := a.b.findIP(a.b.zone(req.Zone), req.IP)
+	if ip == nil {
+		return nil, notFound("instance_ip", req.IP)
+	}
+
+	return &instance.GetIPResponse{IP: copyIP(ip)}, nil
+}
+
+func (a *instanceAPI) UpdateIP(req *instance.UpdateIPRequest, opts ...scw.RequestOption) (*instance.UpdateIPResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	ip := a.b.findIP(a.b.zone(req.Zone), req.IP)
+	if ip == nil {
+		return nil, notFound("instance_ip", req.IP)
+	}
+
+	if req.Reverse != nil {
+		if req.Reverse.Null {
+			ip.Reverse = nil
+		} else {
+			ip.Reverse = scw.StringPtr(req.Reverse.Value)
+		}
+	}
+
+	if req.Tags != nil {
+		ip.Tags = append([]string{}, *req.Tags...)
+	}
+
+	if req.Server != nil {
+		var newServer *instance.Server
+		if !req.Server.Null {
+			var ok bool
+			newServer, ok = a.b.servers[req.Server.Value]
+			if !ok || newServer.Zone != ip.Zone {
+				return nil, notFound("instance_server", req.Server.Value)
+			}
+		}
+
+		// detach the IP from its current server
+		if ip.Server != nil {
+			if oldServer, ok := a.b.servers[ip.Server.ID]; ok {
+				oldServer.PublicIP = nil
+			}
+			ip.Server = nil
+		}
+
+		if newServer != nil {
+			// the dynamic IP of the server is released, a flexible one is detached
+			if newServer.PublicIP != nil && !newServer.PublicIP.Dynamic {
+				if oldIP, ok := a.b.ips[newServer.PublicIP.ID]; ok {
+					oldIP.Server = nil
+				}
+			}
+			newServer.PublicIP = &instance.ServerIP{
+				ID:      ip.ID,
+				Address: ip.Address,
+				Dynamic: false,
+			}
+			ip.Server = &instance.ServerSummary{
+				ID:   newServer.ID,
+				Name: newServer.Name,
+			}
+		}
+	}
+
+	return &instance.UpdateIPResponse{IP: copyIP(ip)}, nil
+}
+
+func (a *instanceAPI) CreateIP(req *instance.CreateIPRequest, opts ...scw.RequestOption) (*instance.CreateIPResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	ip := &instance.IP{
+		ID:      a.b.newID(),
+		Address: net.IPv4(51, 15, 255, byte(len(a.b.ips)+1)),
+		Zone:    a.b.zone(req.Zone),
+		Tags:    append([]string{}, req.Tags...),
+	}
+	if req.Project != nil {
+		ip.Project = *req.Project
+	}
+	if req.Server != nil {
+		server, ok := a.b.servers[*req.Server]
+		if !ok || server.Zone != ip.Zone {
+			return nil, notFound("instance_server", *req.Server)
+		}
+		server.PublicIP = &instance.ServerIP{
+			ID:      ip.ID,
+			Address: ip.Address,
+		}
+		ip.Server = &instance.ServerSummary{
+			ID:   server.ID,
+			Name: server.Name,
+		}
+	}
+	a.b.ips[ip.ID] = ip
+
+	return &instance.CreateIPResponse{IP: copyIP(ip)}, nil
+}
+
+func (a *instanceAPI) DeleteIP(req *instance.DeleteIPRequest, opts ...scw.RequestOption) error {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	ip := a.b.findIP(a.b.zone(req.Zone), req.IP)
+	if ip == nil {
+		return notFound("instance_ip", req.IP)
+	}
+
+	if ip.Server != nil {
+		if server, ok := a.b.servers[ip.Server.ID]; ok {
+			server.PublicIP = nil
+		}
+	}
+	delete(a.b.ips, ip.ID)
+
+	return nil
+}
+
+func (a *instanceAPI) ListSecurityGroupRules(req *instance.ListSecurityGroupRulesRequest, opts ...scw.RequestOption) (*instance.ListSecurityGroupRulesResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	if zone, ok := a.b.securityGroups[req.SecurityGroupID]; !ok || zone != a.b.zone(req.Zone) {
+		return nil, notFound("instance_security_group", req.SecurityGroupID)
+	}
+
+	rules := append([]*instance.SecurityGroupRule{}, a.b.securityGroupRules[req.SecurityGroupID]...)
+	return &instance.ListSecurityGroupRulesResponse{
+		Rules:      rules,
+		TotalCount: uint32(len(rules)),
+	}, nil
+}
+
+func (a *instanceAPI) CreateSecurityGroupRule(req *instance.CreateSecurityGroupRuleRequest, opts ...scw.RequestOption) (*instance.CreateSecurityGroupRuleResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	zone, ok := a.b.securityGroups[req.SecurityGroupID]
+	if !ok || zone != a.b.zone(req.Zone) {
+		return nil, notFound("instance_security_group", req.SecurityGroupID)
+	}
+
+	rule := &instance.SecurityGroupRule{
+		ID:           a.b.newID(),
+		Protocol:     req.Protocol,
+		Direction:    req.Direction,
+		Action:       req.Action,
+		IPRange:      req.IPRange,
+		DestPortFrom: req.DestPortFrom,
+		DestPortTo:   req.DestPortTo,
+		Position:     uint32(len(a.b.securityGroupRules[req.SecurityGroupID]) + 1),
+		Editable:     true,
+		Zone:         zone,
+	}
+	a.b.securityGroupRules[req.SecurityGroupID] = append(a.b.securityGroupRules[req.SecurityGroupID], rule)
+
+	return &instance.CreateSecurityGroupRuleResponse{Rule: rule}, nil
+}
+
+func (a *instanceAPI) DeleteSecurityGroupRule(req *instance.DeleteSecurityGroupRuleRequest, opts ...scw.RequestOption) error {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	rules := a.b.securityGroupRules[req.SecurityGroupID]
+	for i, rule := range rules {
+		if rule.ID == req.SecurityGroupRuleID {
+			a.b.securityGroupRules[req.SecurityGroupID] = append(rules[:i:i], rules[i+1:]...)
+			return nil
+		}
+	}
+
+	return notFound("instance_security_group_rule", req.SecurityGroupRuleID)
+}
+
+// findIP returns the flexible IP matching the ID or the address, in any zone if zone is empty
+func (b *Backend) findIP(zone scw.Zone, idOrAddress string) *instance.IP {
+	address := net.ParseIP(idOrAddress)
+	for _, ip := range b.ips {
+		if zone != "" && ip.Zone != zone {
+			continue
+		}
+		if ip.ID == idOrAddress || (address != nil && ip.Address.Equal(address)) {
+			return ip
+		}
+	}
+	return nil
+}
+
+func hasTags(tags []string, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func copyServer(server *instance.Server) *instance.Server {
+	s := *server
+	if server.PublicIP != nil {
+		publicIP := *server.PublicIP
+		s.PublicIP = &publicIP
+	}
+	if server.IPv6 != nil {
+		ipv6 := *server.IPv6
+		s.IPv6 = &ipv6
+	}
+	s.Tags = append([]string{}, server.Tags...)
+	return &s
+}
+
+func copyIP(ip *instance.IP) *instance.IP {
+	i := *ip
+	if ip.Server != nil {
+		server := *ip.Server
+		i.Server = &server
+	}
+	i.Tags = append([]string{}, ip.Tags...)
+	return &i
+}
@@ -0,0 +1,108 @@
+package fake
+
+import (
+	rdb "github.com/scaleway/scaleway-sdk-go/api/rdb/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+type rdbAPI struct {
+	b *Backend
+}
+
+func (a *rdbAPI) GetInstance(req *rdb.GetInstanceRequest, opts ...scw.RequestOption) (*rdb.Instance, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	db, err := a.b.getDatabase(req.Region, req.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	dbCopy := *db
+	return &dbCopy, nil
+}
+
+func (a *rdbAPI) ListInstanceACLRules(req *rdb.ListInstanceACLRulesRequest, opts ...scw.RequestOption) (*rdb.ListInstanceACLRulesResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	if _, err := a.b.getDatabase(req.Region, req.InstanceID); err != nil {
+		return nil, err
+	}
+
+	rules := append([]*rdb.ACLRule{}, a.b.databaseACLs[req.InstanceID]...)
+	return &rdb.ListInstanceACLRulesResponse{
+		Rules:      rules,
+		TotalCount: uint32(len(rules)),
+	}, nil
+}
+
+func (a *rdbAPI) AddInstanceACLRules(req *rdb.AddInstanceACLRulesRequest, opts ...scw.RequestOption) (*rdb.AddInstanceACLRulesResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	if _, err := a.b.getDatabase(req.Region, req.InstanceID); err != nil {
+		return nil, err
+	}
+
+	resp := &rdb.AddInstanceACLRulesResponse{}
+	for _, r := range req.Rules {
+		rule := &rdb.ACLRule{
+			IP:          r.IP,
+			Protocol:    rdb.ACLRuleProtocolTCP,
+			Direction:   rdb.ACLRuleDirectionInbound,
+			Action:      rdb.ACLRuleActionAllow,
+			Description: r.Description,
+		}
+
+		// like the real API, a rule with the same IP is replaced
+		rules := a.b.databaseACLs[req.InstanceID]
+		for i := range rules {
+			if rules[i].IP.String() == r.IP.String() {
+				rules = append(rules[:i:i], rules[i+1:]...)
+				break
+			}
+		}
+		a.b.databaseACLs[req.InstanceID] = append(rules, rule)
+		resp.Rules = append(resp.Rules, rule)
+	}
+
+	return resp, nil
+}
+
+func (a *rdbAPI) DeleteInstanceACLRules(req *rdb.DeleteInstanceACLRulesRequest, opts ...scw.RequestOption) (*rdb.DeleteInstanceACLRulesResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	if _, err := a.b.getDatabase(req.Region, req.InstanceID); err != nil {
+		return nil, err
+	}
+
+	resp := &rdb.DeleteInstanceACLRulesResponse{}
+	kept := []*rdb.ACLRule{}
+	for _, rule := range a.b.databaseACLs[req.InstanceID] {
+		deleted := false
+		for _, ip := range req.ACLRuleIPs {
+			if ip == rule.IP.String() || ip == rule.IP.IP.String() {
+				deleted = true
+				break
+			}
+		}
+		if deleted {
+			resp.Rules = append(resp.Rules, rule)
+			continue
+		}
+		kept = append(kept, rule)
+	}
+	a.b.databaseACLs[req.InstanceID] = kept
+
+	return resp, nil
+}
+
+func (b *Backend) getDatabase(region scw.Region, id string) (*rdb.Instance, error) {
+	db, ok := b.databases[id]
+	if !ok || db.Region != b.region(region) {
+		return nil, notFound("instance", id)
+	}
+	return db, nil
+}
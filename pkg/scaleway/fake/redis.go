@@ -0,0 +1,73 @@
+package fake
+
+import (
+	redis "github.com/scaleway/scaleway-sdk-go/api/redis/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+type redisAPI struct {
+	b *Backend
+}
+
+func (a *redisAPI) GetCluster(req *redis.GetClusterRequest, opts ...scw.RequestOption) (*redis.Cluster, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	cluster, ok := a.b.redisClusters[req.ClusterID]
+	if !ok || cluster.Zone != a.b.zone(req.Zone) {
+		return nil, notFound("cluster", req.ClusterID)
+	}
+
+	return copyCluster(cluster), nil
+}
+
+func (a *redisAPI) AddACLRules(req *redis.AddACLRulesRequest, opts ...scw.RequestOption) (*redis.AddACLRulesResponse, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	cluster, ok := a.b.redisClusters[req.ClusterID]
+	if !ok || cluster.Zone != a.b.zone(req.Zone) {
+		return nil, notFound("cluster", req.ClusterID)
+	}
+
+	resp := &redis.AddACLRulesResponse{}
+	for _, r := range req.ACLRules {
+		ipCidr := r.IPCidr
+		rule := &redis.ACLRule{
+			ID:          a.b.newID(),
+			IPCidr:      &ipCidr,
+			Description: scw.StringPtr(r.Description),
+		}
+		cluster.ACLRules = append(cluster.ACLRules, rule)
+		resp.ACLRules = append(resp.ACLRules, rule)
+	}
+	resp.TotalCount = uint32(len(resp.ACLRules))
+
+	return resp, nil
+}
+
+func (a *redisAPI) DeleteACLRule(req *redis.DeleteACLRuleRequest, opts ...scw.RequestOption) (*redis.Cluster, error) {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+
+	zone := a.b.zone(req.Zone)
+	for _, cluster := range a.b.redisClusters {
+		if cluster.Zone != zone {
+			continue
+		}
+		for i, rule := range cluster.ACLRules {
+			if rule.ID == req.ACLID {
+				cluster.ACLRules = append(cluster.ACLRules[:i:i], cluster.ACLRules[i+1:]...)
+				return copyCluster(cluster), nil
+			}
+		}
+	}
+
+	return nil, notFound("acl_rule", req.ACLID)
+}
+
+func copyCluster(cluster *redis.Cluster) *redis.Cluster {
+	c := *cluster
+	c.ACLRules = append([]*redis.ACLRule{}, cluster.ACLRules...)
+	return &c
+}
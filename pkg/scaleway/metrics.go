@@ -0,0 +1,138 @@
+package scaleway
+
+import (
+	"time"
+
+	"github.com/Sh4d1/scaleway-k8s-node-coffee/pkg/metrics"
+	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
+	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	rdb "github.com/scaleway/scaleway-sdk-go/api/rdb/v1"
+	redis "github.com/scaleway/scaleway-sdk-go/api/redis/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+// WithMetrics returns a Client recording the latency and the errors of every call made through c
+func WithMetrics(c *Client) *Client {
+	return &Client{
+		Instance: &instanceMetrics{c.Instance},
+		RDB:      &rdbMetrics{c.RDB},
+		Redis:    &redisMetrics{c.Redis},
+		Domain:   &domainMetrics{c.Domain},
+	}
+}
+
+type instanceMetrics struct {
+	api InstanceAPI
+}
+
+func (a *instanceMetrics) ListServers(req *instance.ListServersRequest, opts ...scw.RequestOption) (resp *instance.ListServersResponse, err error) {
+	defer metrics.ObserveScalewayRequest("instance", "ListServers", time.Now(), &err)
+	return a.api.ListServers(req, opts...)
+}
+
+func (a *instanceMetrics) GetServer(req *instance.GetServerRequest, opts ...scw.RequestOption) (resp *instance.GetServerResponse, err error) {
+	defer metrics.ObserveScalewayRequest("instance", "GetServer", time.Now(), &err)
+	return a.api.GetServer(req, opts...)
+}
+
+func (a *instanceMetrics) ListIPs(req *instance.ListIPsRequest, opts ...scw.RequestOption) (resp *instance.ListIPsResponse, err error) {
+	defer metrics.ObserveScalewayRequest("instance", "ListIPs", time.Now(), &err)
+	return a.api.ListIPs(req, opts...)
+}
+
+func (a *instanceMetrics) GetIP(req *instance.GetIPRequest, opts ...scw.RequestOption) (resp *instance.GetIPResponse, err error) {
+	defer metrics.ObserveScalewayRequest("instance", "GetIP", time.Now(), &err)
+	return a.api.GetIP(req, opts...)
+}
+
+func (a *instanceMetrics) UpdateIP(req *instance.UpdateIPRequest, opts ...scw.RequestOption) (resp *instance.UpdateIPResponse, err error) {
+	defer metrics.ObserveScalewayRequest("instance", "UpdateIP", time.Now(), &err)
+	return a.api.UpdateIP(req, opts...)
+}
+
+func (a *instanceMetrics) CreateIP(req *instance.CreateIPRequest, opts ...scw.RequestOption) (resp *instance.CreateIPResponse, err error) {
+	defer metrics.ObserveScalewayRequest("instance", "CreateIP", time.Now(), &err)
+	return a.api.CreateIP(req, opts...)
+}
+
+func (a *instanceMetrics) DeleteIP(req *instance.DeleteIPRequest, opts ...scw.RequestOption) (err error) {
+	defer metrics.ObserveScalewayRequest("instance", "DeleteIP", time.Now(), &err)
+	return a.api.DeleteIP(req, opts...)
+}
+
+func (a *instanceMetrics) ListSecurityGroupRules(req *instance.ListSecurityGroupRulesRequest, opts ...scw.RequestOption) (resp *instance.ListSecurityGroupRulesResponse, err error) {
+	defer metrics.ObserveScalewayRequest("instance", "ListSecurityGroupRules", time.Now(), &err)
+	return a.api.ListSecurityGroupRules(req, opts...)
+}
+
+func (a *instanceMetrics) CreateSecurityGroupRule(req *instance.CreateSecurityGroupRuleRequest, opts ...scw.RequestOption) (resp *instance.CreateSecurityGroupRuleResponse, err error) {
+	defer metrics.ObserveScalewayRequest("instance", "CreateSecurityGroupRule", time.Now(), &err)
+	return a.api.CreateSecurityGroupRule(req, opts...)
+}
+
+func (a *instanceMetrics) DeleteSecurityGroupRule(req *instance.DeleteSecurityGroupRuleRequest, opts ...scw.RequestOption) (err error) {
+	defer metrics.ObserveScalewayRequest("instance", "DeleteSecurityGroupRule", time.Now(), &err)
+	return a.api.DeleteSecurityGroupRule(req, opts...)
+}
+
+type rdbMetrics struct {
+	api RDBAPI
+}
+
+func (a *rdbMetrics) GetInstance(req *rdb.GetInstanceRequest, opts ...scw.RequestOption) (resp *rdb.Instance, err error) {
+	defer metrics.ObserveScalewayRequest("rdb", "GetInstance", time.Now(), &err)
+	return a.api.GetInstance(req, opts...)
+}
+
+func (a *rdbMetrics) ListInstanceACLRules(req *rdb.ListInstanceACLRulesRequest, opts ...scw.RequestOption) (resp *rdb.ListInstanceACLRulesResponse, err error) {
+	defer metrics.ObserveScalewayRequest("rdb", "ListInstanceACLRules", time.Now(), &err)
+	return a.api.ListInstanceACLRules(req, opts...)
+}
+
+func (a *rdbMetrics) AddInstanceACLRules(req *rdb.AddInstanceACLRulesRequest, opts ...scw.RequestOption) (resp *rdb.AddInstanceACLRulesResponse, err error) {
+	defer metrics.ObserveScalewayRequest("rdb", "AddInstanceACLRules", time.Now(), &err)
+	return a.api.AddInstanceACLRules(req, opts...)
+}
+
+func (a *rdbMetrics) DeleteInstanceACLRules(req *rdb.DeleteInstanceACLRulesRequest, opts ...scw.RequestOption) (resp *rdb.DeleteInstanceACLRulesResponse, err error) {
+	defer metrics.ObserveScalewayRequest("rdb", "DeleteInstanceACLRules", time.Now(), &err)
+	return a.api.DeleteInstanceACLRules(req, opts...)
+}
+
+type redisMetrics struct {
+	api RedisAPI
+}
+
+func (a *redisMetrics) GetCluster(req *redis.GetClusterRequest, opts ...scw.RequestOption) (resp *redis.Cluster, err error) {
+	defer metrics.ObserveScalewayRequest("redis", "GetCluster", time.Now(), &err)
+	return a.api.GetCluster(req, opts...)
+}
+
+func (a *redisMetrics) AddACLRules(req *redis.AddACLRulesRequest, opts ...scw.RequestOption) (resp *redis.AddACLRulesResponse, err error) {
+	defer metrics.ObserveScalewayRequest("redis", "AddACLRules", time.Now(), &err)
+	return a.api.AddACLRules(req, opts...)
+}
+
+func (a *redisMetrics) DeleteACLRule(req *redis.DeleteACLRuleRequest, opts ...scw.RequestOption) (resp *redis.Cluster, err error) {
+	defer metrics.ObserveScalewayRequest("redis", "DeleteACLRule", time.Now(), &err)
+	return a.api.DeleteACLRule(req, opts...)
+}
+
+type domainMetrics struct {
+	api DomainAPI
+}
+
+func (a *domainMetrics) ListDNSZones(req *dns.ListDNSZonesRequest, opts ...scw.RequestOption) (resp *dns.ListDNSZonesResponse, err error) {
+	defer metrics.ObserveScalewayRequest("domain", "ListDNSZones", time.Now(), &err)
+	return a.api.ListDNSZones(req, opts...)
+}
+
+func (a *domainMetrics) ListDNSZoneRecords(req *dns.ListDNSZoneRecordsRequest, opts ...scw.RequestOption) (resp *dns.ListDNSZoneRecordsResponse, err error) {
+	defer metrics.ObserveScalewayRequest("domain", "ListDNSZoneRecords", time.Now(), &err)
+	return a.api.ListDNSZoneRecords(req, opts...)
+}
+
+func (a *domainMetrics) UpdateDNSZoneRecords(req *dns.UpdateDNSZoneRecordsRequest, opts ...scw.RequestOption) (resp *dns.UpdateDNSZoneRecordsResponse, err error) {
+	defer metrics.ObserveScalewayRequest("domain", "UpdateDNSZoneRecords", time.Now(), &err)
+	return a.api.UpdateDNSZoneRecords(req, opts...)
+}
@@ -0,0 +1,68 @@
+package scaleway
+
+import (
+	dns "github.com/scaleway/scaleway-sdk-go/api/domain/v2beta1"
+	instance "github.com/scaleway/scaleway-sdk-go/api/instance/v1"
+	rdb "github.com/scaleway/scaleway-sdk-go/api/rdb/v1"
+	redis "github.com/scaleway/scaleway-sdk-go/api/redis/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+// InstanceAPI holds the calls made to the Instance API by the controllers
+type InstanceAPI interface {
+	ListServers(req *instance.ListServersRequest, opts ...scw.RequestOption) (*instance.ListServersResponse, error)
+	GetServer(req *instance.GetServerRequest, opts ...scw.RequestOption) (*instance.GetServerResponse, error)
+
+	ListIPs(req *instance.ListIPsRequest, opts ...scw.RequestOption) (*instance.ListIPsResponse, error)
+	GetIP(req *instance.GetIPRequest, opts ...scw.RequestOption) (*instance.GetIPResponse, error)
+	UpdateIP(req *instance.UpdateIPRequest, opts ...scw.RequestOption) (*instance.UpdateIPResponse, error)
+	CreateIP(req *instance.CreateIPRequest, opts ...scw.RequestOption) (*instance.CreateIPResponse, error)
+	DeleteIP(req *instance.DeleteIPRequest, opts ...scw.RequestOption) error
+
+	ListSecurityGroupRules(req *instance.ListSecurityGroupRulesRequest, opts ...scw.RequestOption) (*instance.ListSecurityGroupRulesResponse, error)
+	CreateSecurityGroupRule(req *instance.CreateSecurityGroupRuleRequest, opts ...scw.RequestOption) (*instance.CreateSecurityGroupRuleResponse, error)
+	DeleteSecurityGroupRule(req *instance.DeleteSecurityGroupRuleRequest, opts ...scw.RequestOption) error
+}
+
+// RDBAPI holds the calls made to the Database API by the controllers
+type RDBAPI interface {
+	GetInstance(req *rdb.GetInstanceRequest, opts ...scw.RequestOption) (*rdb.Instance, error)
+	ListInstanceACLRules(req *rdb.ListInstanceACLRulesRequest, opts ...scw.RequestOption) (*rdb.ListInstanceACLRulesResponse, error)
+	AddInstanceACLRules(req *rdb.AddInstanceACLRulesRequest, opts ...scw.RequestOption) (*rdb.AddInstanceACLRulesResponse, error)
+	DeleteInstanceACLRules(req *rdb.DeleteInstanceACLRulesRequest, opts ...scw.RequestOption) (*rdb.DeleteInstanceACLRulesResponse, error)
+}
+
+// RedisAPI holds the calls made to the Redis API by the controllers
+type RedisAPI interface {
+	GetCluster(req *redis.GetClusterRequest, opts ...scw.RequestOption) (*redis.Cluster, error)
+	AddACLRules(req *redis.AddACLRulesRequest, opts ...scw.RequestOption) (*redis.AddACLRulesResponse, error)
+	DeleteACLRule(req *redis.DeleteACLRuleRequest, opts ...scw.RequestOption) (*redis.Cluster, error)
+}
+
+// DomainAPI holds the calls made to the Domain API by the controllers
+type DomainAPI interface {
+	ListDNSZones(req *dns.ListDNSZonesRequest, opts ...scw.RequestOption) (*dns.ListDNSZonesResponse, error)
+	ListDNSZoneRecords(req *dns.ListDNSZoneRecordsRequest, opts ...scw.RequestOption) (*dns.ListDNSZoneRecordsResponse, error)
+	UpdateDNSZoneRecords(req *dns.UpdateDNSZoneRecordsRequest, opts ...scw.RequestOption) (*dns.UpdateDNSZoneRecordsResponse, error)
+}
+
+// Client groups all the Scaleway APIs used by the controllers
+type Client struct {
+	Instance InstanceAPI
+	RDB      RDBAPI
+	Redis    RedisAPI
+	Domain   DomainAPI
+
+	// DryRun is set when the mutating calls are not sent, see WithDryRun
+	DryRun bool
+}
+
+// NewClient returns a Client backed by the real Scaleway APIs
+func NewClient(scwClient *scw.Client) *Client {
+	return &Client{
+		Instance: instance.NewAPI(scwClient),
+		RDB:      rdb.NewAPI(scwClient),
+		Redis:    redis.NewAPI(scwClient),
+		Domain:   dns.NewAPI(scwClient),
+	}
+}